@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+	netatmo "github.com/dhogborg/netatmo-api-go"
+	"golang.org/x/oauth2"
+)
+
+// authStatus is what auth status reports for one profile
+// Account : E-mail address of the account, from the API or the configured user
+// Scopes : Scopes granted with the token, empty if the server did not tell
+// Expiry : When the access token expires, it is refreshed automatically after that
+// Verified : The token was accepted by the API, not set with --offline
+type authStatus struct {
+	Profile   string     `json:"profile"`
+	Account   string     `json:"account,omitempty"`
+	ClientID  string     `json:"client_id,omitempty"`
+	TokenFile string     `json:"token_file"`
+	LoggedIn  bool       `json:"logged_in"`
+	Scopes    []string   `json:"scopes,omitempty"`
+	Expiry    *time.Time `json:"expiry,omitempty"`
+	Expired   bool       `json:"expired"`
+	Verified  bool       `json:"verified"`
+	Error     string     `json:"error,omitempty"`
+}
+
+// logoutResult is what auth logout reports for one profile
+// Revoked : The token was revoked at the revocation endpoint
+// Removed : The token file was deleted
+type logoutResult struct {
+	Profile   string `json:"profile"`
+	TokenFile string `json:"token_file"`
+	Revoked   bool   `json:"revoked"`
+	Removed   bool   `json:"removed"`
+	Error     string `json:"error,omitempty"`
+}
+
+// authStatusCommand reports the login state of every profile. Unless offline,
+// the token is checked against the API, which refreshes it when expired.
+func authStatusCommand(ctx context.Context, c *cli.Context) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	results := []authStatus{}
+	errs := []error{}
+	for _, p := range profiles {
+		status, err := authStatusOf(ctx, p, c.Bool("offline"))
+		if err != nil {
+			status.Error = redact(err.Error())
+		}
+		results = append(results, status)
+		errs = append(errs, err)
+	}
+
+	if c.Bool("json") {
+		authStatusJSONPrint(results)
+	} else {
+		for i, result := range results {
+			if i > 0 {
+				fmt.Println()
+			}
+			authStatusPrint(result)
+		}
+	}
+
+	exitOnAuthErrors(ctx, errs, "auth status failed")
+}
+
+// authStatusOf collects the status of p, the error tells why it is not usable
+func authStatusOf(ctx context.Context, p *Profile, offline bool) (authStatus, error) {
+	status := authStatus{
+		Profile:   p.Name,
+		Account:   p.User,
+		TokenFile: tokenPath(p),
+	}
+
+	id, _, err := clientCredentials(p)
+	if err == nil {
+		status.ClientID = id
+	}
+
+	token, err := loadToken(status.TokenFile)
+	if os.IsNotExist(err) {
+		return status, errNotLoggedIn()
+	} else if err != nil {
+		return status, err
+	}
+	status.LoggedIn = true
+	tokenStatus(&status, token)
+
+	if offline {
+		return status, nil
+	}
+
+	config, err := clientConfig(p)
+	if err != nil {
+		return status, err
+	}
+	n, err := newClient(ctx, p, config)
+	if err != nil {
+		return status, err
+	}
+	dc, err := n.Read(ctx)
+	if err != nil {
+		return status, err
+	}
+	status.Verified = true
+	if mail := dc.User().Mail; mail != "" {
+		status.Account = mail
+	}
+
+	// the token may have been refreshed by the request
+	if token, err := loadToken(status.TokenFile); err == nil {
+		tokenStatus(&status, token)
+	}
+	return status, nil
+}
+
+// tokenStatus fills in what the token tells about itself
+func tokenStatus(status *authStatus, token *oauth2.Token) {
+	status.Scopes = netatmo.TokenScopes(token)
+	status.Expiry = nil
+	status.Expired = false
+	if !token.Expiry.IsZero() {
+		expiry := token.Expiry
+		status.Expiry = &expiry
+		status.Expired = expiry.Before(time.Now())
+	}
+}
+
+func authStatusPrint(status authStatus) {
+	fmt.Printf("Profile:    %s\n", status.Profile)
+	if status.Account != "" {
+		fmt.Printf("Account:    %s\n", status.Account)
+	}
+	if status.ClientID != "" {
+		fmt.Printf("Client ID:  %s\n", status.ClientID)
+	}
+	fmt.Printf("Token file: %s\n", status.TokenFile)
+
+	if status.LoggedIn {
+		scopes := "unknown"
+		if len(status.Scopes) > 0 {
+			scopes = strings.Join(status.Scopes, " ")
+		}
+		fmt.Printf("Scopes:     %s\n", scopes)
+
+		if status.Expiry != nil {
+			left := status.Expiry.Sub(time.Now())
+			if status.Expired {
+				fmt.Printf("Expires:    %s (expired, refreshed on the next request)\n", status.Expiry.Format(time.RFC3339))
+			} else {
+				fmt.Printf("Expires:    %s (in %s)\n", status.Expiry.Format(time.RFC3339), left-left%time.Second)
+			}
+		}
+	}
+
+	switch {
+	case status.Error != "":
+		fmt.Printf("Status:     %s\n", status.Error)
+	case status.Verified:
+		fmt.Println("Status:     logged in, token accepted by the API")
+	default:
+		fmt.Println("Status:     logged in, not verified")
+	}
+}
+
+// authLogoutCommand revokes the token of every profile where a revocation
+// endpoint is configured and deletes the token file
+func authLogoutCommand(ctx context.Context, c *cli.Context) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	results := []logoutResult{}
+	errs := []error{}
+	for _, p := range profiles {
+		revokeURL := c.String("revoke-url")
+		if revokeURL == "" {
+			revokeURL = p.RevokeURL
+		}
+
+		result, err := logout(ctx, p, revokeURL)
+		if err != nil {
+			result.Error = redact(err.Error())
+		}
+		results = append(results, result)
+		errs = append(errs, err)
+	}
+
+	if c.Bool("json") {
+		logoutJSONPrint(results)
+	} else {
+		for _, result := range results {
+			logoutPrint(result)
+		}
+	}
+
+	exitOnAuthErrors(ctx, errs, "logout failed")
+}
+
+func logoutPrint(result logoutResult) {
+	switch {
+	case result.Error != "":
+		fmt.Printf("%s: %s\n", result.Profile, result.Error)
+	case !result.Removed:
+		fmt.Printf("%s: not logged in\n", result.Profile)
+	case result.Revoked:
+		fmt.Printf("%s: token revoked and %s deleted\n", result.Profile, result.TokenFile)
+	default:
+		fmt.Printf("%s: %s deleted, the token was not revoked\n", result.Profile, result.TokenFile)
+	}
+}
+
+// logout revokes the token of p at revokeURL, when given, and deletes the token file.
+// The file is deleted even when the revocation fails, the error is returned.
+func logout(ctx context.Context, p *Profile, revokeURL string) (logoutResult, error) {
+	result := logoutResult{
+		Profile:   p.Name,
+		TokenFile: tokenPath(p),
+	}
+
+	token, err := loadToken(result.TokenFile)
+	if os.IsNotExist(err) {
+		return result, nil
+	}
+
+	var revokeErr error
+	if err == nil && revokeURL != "" {
+		config, err := clientConfig(p)
+		if err == nil {
+			config.RevokeURL = revokeURL
+			err = netatmo.RevokeToken(ctx, config, token)
+		}
+		if err != nil {
+			revokeErr = fmt.Errorf("unable to revoke token: %s", err.Error())
+		}
+		result.Revoked = err == nil
+	}
+
+	if err := os.Remove(result.TokenFile); err != nil {
+		return result, err
+	}
+	result.Removed = true
+
+	return result, revokeErr
+}
+
+// authStatusJSONPrint prints the statuses as one object, or keyed by profile with several
+func authStatusJSONPrint(results []authStatus) {
+	if len(results) == 1 {
+		authJSONPrint(results[0])
+		return
+	}
+	block := map[string]authStatus{}
+	for _, result := range results {
+		block[result.Profile] = result
+	}
+	authJSONPrint(block)
+}
+
+// logoutJSONPrint prints the logout results as one object, or keyed by profile with several
+func logoutJSONPrint(results []logoutResult) {
+	if len(results) == 1 {
+		authJSONPrint(results[0])
+		return
+	}
+	block := map[string]logoutResult{}
+	for _, result := range results {
+		block[result.Profile] = result
+	}
+	authJSONPrint(block)
+}
+
+// authJSONPrint prints v as json on a line of its own
+func authJSONPrint(v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(string(b))
+}
+
+// exitOnAuthErrors logs errs with msg and exits like getDevices, with the status
+// of the first error when all profiles failed and exitPartial when some did
+func exitOnAuthErrors(ctx context.Context, errs []error, msg string) {
+	var firstErr error
+	failed := 0
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+		log.WithFields(errorFields(err, profileFields(profiles[i]))).Error(msg)
+		failed++
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	if failed == 0 {
+		return
+	}
+	exitIfDone(ctx)
+	if failed == len(errs) {
+		os.Exit(exitStatus(firstErr))
+	}
+	os.Exit(exitPartial)
+}
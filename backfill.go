@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+	"github.com/dhogborg/atnetgo/stations"
+	netatmo "github.com/dhogborg/netatmo-api-go"
+)
+
+// checkpoint is the layout of a backfill checkpoint file, one per module and scale
+// Station, Module : Names of the station and the module, for people reading the file
+// Types : Measure types backfilled, a checkpoint for other types is not resumed
+// Last : Time of the last measure written
+type checkpoint struct {
+	Station string    `json:"station"`
+	Module  string    `json:"module"`
+	Types   []string  `json:"types"`
+	Last    time.Time `json:"last"`
+}
+
+// checkpointDir returns where the backfill checkpoints of the account are kept,
+// next to the token file by default
+func checkpointDir(c *cli.Context, p *Profile) string {
+	if dir := c.String("checkpoint-dir"); dir != "" {
+		return expandHome(dir)
+	}
+	return tokenPath(p) + ".backfill"
+}
+
+// checkpointPath returns the checkpoint file of a module at scale
+func checkpointPath(dir, moduleID, scale string) string {
+	return filepath.Join(dir, strings.Replace(moduleID, ":", "", -1)+"-"+scale+".json")
+}
+
+// loadCheckpoint reads the checkpoint at path, an empty one if there is none yet
+func loadCheckpoint(path string) (checkpoint, error) {
+	cp := checkpoint{}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cp, nil
+	} else if err != nil {
+		return cp, err
+	}
+	if err := json.Unmarshal(b, &cp); err != nil {
+		return cp, fmt.Errorf("unable to parse %s: %s", path, err.Error())
+	}
+	return cp, nil
+}
+
+// saveCheckpoint writes cp to path
+func saveCheckpoint(path string, cp checkpoint) error {
+	b, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, b)
+}
+
+// backfill writes the measures of every selected module in InfluxDB line format,
+// to stdout or to --influx-url. Each chunk of measures is checkpointed once written,
+// so an interrupted backfill resumes after the last measure written. Requests go
+// through the rate limiter of the profile like any other.
+func backfill(ctx context.Context, c *cli.Context) {
+	p := profile
+
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	q, err := historyQuery(c, time.Now())
+	if err != nil {
+		fatal(ctx, err, profileFields(p), "configuration error")
+	}
+	if q.End.IsZero() {
+		q.End = time.Now()
+	}
+
+	var out measureWriter = stdoutWriter{}
+	if url := c.String("influx-url"); url != "" {
+		out = &influxWriter{url: url, token: c.String("influx-token")}
+	}
+
+	devices, err := fetchStations(ctx, p)
+	if err != nil {
+		fatal(ctx, err, profileFields(p), "unable to read account")
+	}
+	modules := backfillModules(devices, c.String("module"))
+	if len(modules) == 0 {
+		fatal(ctx, &netatmo.NotFoundError{APIError: &netatmo.APIError{Message: "no module found"}}, profileFields(p), "unable to find module")
+	}
+
+	config, err := clientConfig(p)
+	if err != nil {
+		fatal(ctx, err, profileFields(p), "configuration error")
+	}
+	n, err := newClient(ctx, p, config)
+	if err != nil {
+		fatal(ctx, err, profileFields(p), "unable to read history")
+	}
+
+	b := &backfiller{
+		client:  n,
+		out:     out,
+		printer: stations.InfluxPrinter{Units: p.Units},
+		dir:     checkpointDir(c, p),
+		restart: c.Bool("restart"),
+	}
+	skipped := 0
+	for _, m := range modules {
+		mq := q
+		mq.DeviceID = m.station.ID
+		if m.module != m.station {
+			mq.ModuleID = m.module.ID
+		}
+		mq.Types = moduleTypes(m.module, q.Types)
+		if len(mq.Types) == 0 {
+			continue
+		}
+
+		fields := profileFields(p)
+		fields["station"] = m.station.StationName
+		fields["module"] = m.module.ModuleName
+
+		written, err := b.module(ctx, m, mq, fields)
+		if _, ok := err.(*netatmo.NotFoundError); ok {
+			log.WithFields(errorFields(err, fields)).Warn("skipping module without measures")
+			skipped++
+			continue
+		} else if err != nil {
+			fatal(ctx, err, fields, "unable to backfill module")
+		}
+		log.WithFields(fields).WithField("measures", written).Info("backfilled module")
+	}
+
+	if skipped > 0 {
+		os.Exit(exitPartial)
+	}
+}
+
+// backfiller writes the measures of modules chunk by chunk, with a checkpoint per module
+// client : Reads the measures
+// out : Where the measures are written
+// printer : Formats the measures for out
+// dir : Where the checkpoints are kept, see checkpointDir
+// restart : Ignore the checkpoints and start over
+type backfiller struct {
+	client  *netatmo.Client
+	out     measureWriter
+	printer stations.HistoryPrinter
+	dir     string
+	restart bool
+}
+
+// module writes the measures of m selected by q, after the last measure of its
+// checkpoint unless the checkpoint is for other types, and returns how many it wrote.
+// A zero q.Begin starts when the module was set up. Errors are returned as they are,
+// a module without measures fails with a NotFoundError.
+func (b *backfiller) module(ctx context.Context, m backfillModule, q netatmo.MeasureQuery, fields log.Fields) (int, error) {
+	// without a begin the API returns the newest measures, start where the module started
+	if q.Begin.IsZero() {
+		if q.Begin = m.setup(); q.Begin.IsZero() {
+			return 0, errors.New("the setup date of the module is unknown, give --begin")
+		}
+	}
+
+	path := checkpointPath(b.dir, m.module.ID, q.Scale)
+	cp := checkpoint{}
+	if !b.restart {
+		var err error
+		if cp, err = loadCheckpoint(path); err != nil {
+			return 0, err
+		}
+	}
+	if strings.Join(cp.Types, ",") != strings.Join(q.Types, ",") {
+		cp = checkpoint{Station: m.station.StationName, Module: m.module.ModuleName, Types: q.Types}
+	}
+	if !cp.Last.IsZero() && !cp.Last.Before(q.Begin) {
+		q.Begin = cp.Last.Add(time.Second)
+		log.WithFields(fields).WithField("from", q.Begin.Format(time.RFC3339)).Info("resuming backfill")
+	}
+
+	written := 0
+	for !q.Begin.After(q.End) {
+		q.Limit = netatmo.MaxMeasures
+		measures, err := b.client.ReadMeasures(ctx, q)
+		if err != nil {
+			return written, err
+		}
+		if len(measures) == 0 {
+			break
+		}
+
+		h := &stations.History{Station: m.station.StationName, Module: m.module.ModuleName, Types: q.Types, Measures: measures}
+		if err := b.out.write(ctx, func(w io.Writer) error { return b.printer.PrintHistory(w, h) }); err != nil {
+			return written, err
+		}
+
+		cp.Last = measures[len(measures)-1].Time
+		if err := saveCheckpoint(path, cp); err != nil {
+			return written, err
+		}
+		written += len(measures)
+		log.WithFields(fields).WithFields(log.Fields{
+			"measures": written,
+			"until":    cp.Last.Format(time.RFC3339),
+		}).Debug("backfilled chunk")
+
+		if len(measures) < q.Limit {
+			break
+		}
+		q.Begin = cp.Last.Add(time.Second)
+	}
+	return written, nil
+}
+
+// backfillModule is a module to backfill and the station it belongs to
+type backfillModule struct {
+	station *netatmo.Device
+	module  *netatmo.Device
+}
+
+// setup returns when the module was set up, when the station was for modules
+// that don't tell, and the zero time if neither does
+func (m backfillModule) setup() time.Time {
+	for _, d := range []*netatmo.Device{m.module, m.station} {
+		if d.DateSetup > 0 {
+			return time.Unix(d.DateSetup, 0)
+		}
+	}
+	return time.Time{}
+}
+
+// backfillModules returns the modules of the stations whose name contains name, all for an empty name
+func backfillModules(devices []*netatmo.Device, name string) []backfillModule {
+	modules := []backfillModule{}
+	for _, station := range devices {
+		for _, module := range station.Modules() {
+			if strings.Contains(module.ModuleName, name) {
+				modules = append(modules, backfillModule{station: station, module: module})
+			}
+		}
+	}
+	return modules
+}
+
+// moduleTypes returns the measure types of module among types, all it has for no types
+func moduleTypes(module *netatmo.Device, types []string) []string {
+	known := netatmo.MeasureTypes[module.Type]
+	if len(types) == 0 {
+		return known
+	}
+
+	selected := []string{}
+	for _, t := range types {
+		if contains(known, t) {
+			selected = append(selected, t)
+		}
+	}
+	return selected
+}
+
+// measureWriter writes chunks of line protocol somewhere
+type measureWriter interface {
+	// write writes what print writes, failing if any of it may be lost
+	write(ctx context.Context, print func(w io.Writer) error) error
+}
+
+// stdoutWriter writes to stdout
+type stdoutWriter struct{}
+
+func (stdoutWriter) write(ctx context.Context, print func(w io.Writer) error) error {
+	return print(os.Stdout)
+}
+
+// influxWriter posts to the write endpoint of an InfluxDB server
+// url : The write endpoint with its parameters, e.g. http://localhost:8086/write?db=weather
+// token : API token for InfluxDB 2, empty when none is needed
+type influxWriter struct {
+	url   string
+	token string
+}
+
+func (i *influxWriter) write(ctx context.Context, print func(w io.Writer) error) error {
+	b := &bytes.Buffer{}
+	if err := print(b); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", i.url, b)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if i.token != "" {
+		req.Header.Set("Authorization", "Token "+i.token)
+	}
+
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("influxdb: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dhogborg/atnetgo/netatmotest"
+	"github.com/dhogborg/atnetgo/stations"
+	netatmo "github.com/dhogborg/netatmo-api-go"
+)
+
+// chunkWriter keeps the lines of the chunks written, failing the chunk numbered fail
+type chunkWriter struct {
+	lines  []string
+	chunks int
+	fail   int
+}
+
+func (w *chunkWriter) write(ctx context.Context, print func(w io.Writer) error) error {
+	w.chunks++
+	if w.chunks == w.fail {
+		return errors.New("influxdb is down")
+	}
+	b := &bytes.Buffer{}
+	if err := print(b); err != nil {
+		return err
+	}
+	w.lines = append(w.lines, strings.Split(strings.TrimSpace(b.String()), "\n")...)
+	return nil
+}
+
+// lineTime returns the time of a line in InfluxDB line format
+func lineTime(t *testing.T, line string) time.Time {
+	fields := strings.Fields(line)
+	ns, err := strconv.ParseInt(fields[len(fields)-1], 10, 64)
+	if err != nil {
+		t.Fatalf("no time in %q", line)
+	}
+	return time.Unix(0, ns)
+}
+
+func TestBackfillCheckpoints(t *testing.T) {
+	// 2500 measures five minutes apart, three chunks of at most 1024
+	at := func(i int) time.Time { return time.Unix(1000+int64(i)*300, 0) }
+	series := []netatmotest.Measure{}
+	for i := 0; i < 2500; i++ {
+		series = append(series, netatmotest.Measure{Time: at(i).Unix(), Values: map[string]float64{"Temperature": float64(i)}})
+	}
+	s := netatmotest.NewServer(&netatmotest.Fixtures{Measures: map[string][]netatmotest.Measure{netatmotest.DemoOutdoorID: series}})
+	defer s.Close()
+
+	m := backfillModule{
+		station: &netatmo.Device{ID: netatmotest.DemoStationID, StationName: "Demo", Type: "NAMain", DateSetup: at(0).Unix()},
+		module:  &netatmo.Device{ID: netatmotest.DemoOutdoorID, ModuleName: "Outdoor", Type: "NAModule1"},
+	}
+	both := []string{netatmo.NAModule1Temperature, netatmo.NAModule1Humidity}
+
+	tests := []struct {
+		name       string
+		checkpoint *checkpoint // saved before the run, none when nil
+		begin      time.Time
+		restart    bool
+		fail       int // chunk the writer fails on, 0 for none
+		first      time.Time
+		written    int
+		last       time.Time // in the checkpoint after the run
+		err        bool
+	}{
+		{
+			name:    "no checkpoint starts at the setup date",
+			first:   at(0),
+			written: 2500,
+			last:    at(2499),
+		},
+		{
+			name:    "no checkpoint starts at begin",
+			begin:   at(2000),
+			first:   at(2000),
+			written: 500,
+			last:    at(2499),
+		},
+		{
+			name:       "resumes after an interrupted chunk",
+			checkpoint: &checkpoint{Types: both, Last: at(1023)},
+			first:      at(1024),
+			written:    1476,
+			last:       at(2499),
+		},
+		{
+			name:       "complete checkpoint writes nothing",
+			checkpoint: &checkpoint{Types: both, Last: at(2499)},
+			last:       at(2499),
+		},
+		{
+			name:       "checkpoint for other types starts over",
+			checkpoint: &checkpoint{Types: []string{netatmo.NAModule1Temperature}, Last: at(2000)},
+			first:      at(0),
+			written:    2500,
+			last:       at(2499),
+		},
+		{
+			name:       "checkpoint before begin is not resumed",
+			checkpoint: &checkpoint{Types: both, Last: at(100)},
+			begin:      at(2400),
+			first:      at(2400),
+			written:    100,
+			last:       at(2499),
+		},
+		{
+			name:       "restart ignores the checkpoint",
+			checkpoint: &checkpoint{Types: both, Last: at(2000)},
+			restart:    true,
+			first:      at(0),
+			written:    2500,
+			last:       at(2499),
+		},
+		{
+			name:    "failed chunk keeps the checkpoint of the last one written",
+			fail:    2,
+			first:   at(0),
+			written: 1024,
+			last:    at(1023),
+			err:     true,
+		},
+	}
+
+	for _, test := range tests {
+		dir, err := ioutil.TempDir("", "atnetgo-backfill")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		path := checkpointPath(dir, m.module.ID, netatmo.ScaleMax)
+		if test.checkpoint != nil {
+			if err := saveCheckpoint(path, *test.checkpoint); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		out := &chunkWriter{fail: test.fail}
+		b := &backfiller{
+			client:  netatmo.NewClientWithToken(context.Background(), netatmo.Config{BaseURL: s.URL}, s.Token()),
+			out:     out,
+			printer: stations.InfluxPrinter{Units: stations.UnitsMetric},
+			dir:     dir,
+			restart: test.restart,
+		}
+		q := netatmo.MeasureQuery{
+			DeviceID: m.station.ID,
+			ModuleID: m.module.ID,
+			Scale:    netatmo.ScaleMax,
+			Types:    both,
+			Begin:    test.begin,
+			End:      at(3000),
+		}
+
+		written, err := b.module(context.Background(), m, q, nil)
+		if (err != nil) != test.err {
+			t.Errorf("%s: got error %v", test.name, err)
+		}
+		if written != test.written || len(out.lines) != test.written {
+			t.Errorf("%s: %d measures written, %d lines, want %d", test.name, written, len(out.lines), test.written)
+		}
+		if len(out.lines) > 0 && !lineTime(t, out.lines[0]).Equal(test.first) {
+			t.Errorf("%s: first measure at %s, want %s", test.name, lineTime(t, out.lines[0]), test.first)
+		}
+
+		cp, err := loadCheckpoint(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !cp.Last.Equal(test.last) || strings.Join(cp.Types, ",") != strings.Join(both, ",") {
+			t.Errorf("%s: got checkpoint %+v, want the last measure at %s", test.name, cp, test.last)
+		}
+	}
+}
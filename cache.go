@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/dhogborg/atnetgo/stations"
+	netatmo "github.com/dhogborg/netatmo-api-go"
+)
+
+// cachedDevices is the layout of the device cache file
+// Fetched : When the devices were read from the API
+// Devices : The devices as read, before the station filter
+type cachedDevices struct {
+	Fetched time.Time                 `json:"fetched"`
+	Devices *netatmo.DeviceCollection `json:"devices"`
+}
+
+// cachePath returns where the last device collection of the account is kept,
+// next to the token file by default
+func cachePath(p *Profile) string {
+	if p.CacheFile != "" {
+		return p.CacheFile
+	}
+	return tokenPath(p) + ".cache"
+}
+
+// saveDevices writes the devices read from the API to the cache file of the account
+func saveDevices(p *Profile, dc *netatmo.DeviceCollection) error {
+	b, err := json.Marshal(cachedDevices{Fetched: time.Now(), Devices: dc})
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(cachePath(p), b)
+}
+
+// loadDevices reads the cached devices of the account and when they were fetched
+func loadDevices(p *Profile) (*netatmo.DeviceCollection, time.Time, error) {
+	path := cachePath(p)
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	cached := cachedDevices{}
+	if err := json.Unmarshal(b, &cached); err != nil {
+		return nil, time.Time{}, fmt.Errorf("unable to parse %s: %s", path, err.Error())
+	}
+	if cached.Devices == nil {
+		return nil, time.Time{}, fmt.Errorf("%s holds no devices", path)
+	}
+	return cached.Devices, cached.Fetched, nil
+}
+
+// staleStations returns the cached stations of the account, filtered by the
+// station filter of the profile, and when they were fetched
+func staleStations(p *Profile) ([]*netatmo.Device, time.Time, error) {
+	dc, fetched, err := loadDevices(p)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return stations.Filter(p.Station, dc).Stations(), fetched, nil
+}
+
+// uploadInterval is how often stations upload their measures to Netatmo
+const uploadInterval = 10 * time.Minute
+
+// freshStations returns the cached stations of the account, filtered by the station
+// filter of the profile, if no newer measures can have been uploaded since they were
+// fetched: the cache is younger than maxAge and the next upload of every module is
+// still due. Modules without measures, e.g. out of reach, upload nothing and are skipped,
+// but at least one module must have measured for the cache to tell anything.
+func freshStations(p *Profile, maxAge time.Duration) ([]*netatmo.Device, bool) {
+	dc, fetched, err := loadDevices(p)
+	if err != nil || time.Since(fetched) > maxAge {
+		return nil, false
+	}
+
+	cached := stations.Filter(p.Station, dc).Stations()
+	if len(cached) == 0 {
+		return nil, false
+	}
+
+	now := time.Now()
+	measured := false
+	for _, station := range cached {
+		for _, module := range station.Modules() {
+			if module.DashboardData.LastMeasure == 0 {
+				continue
+			}
+			measured = true
+			last := time.Unix(int64(module.DashboardData.LastMeasure), 0)
+			if !last.Add(uploadInterval).After(now) {
+				return nil, false
+			}
+		}
+	}
+	if !measured {
+		return nil, false
+	}
+	return cached, true
+}
+
+// staleAge formats the age of stale data for people, to the second
+func staleAge(age time.Duration) string {
+	return (age - age%time.Second).String()
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	netatmo "github.com/dhogborg/netatmo-api-go"
+)
+
+func TestFreshStations(t *testing.T) {
+	dir, err := ioutil.TempDir("", "atnetgo-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+	ago := func(d time.Duration) float64 { return float64(now.Add(-d).Unix()) }
+
+	tests := []struct {
+		name     string
+		fetched  time.Duration // age of the cache, no cache file when 0
+		station  float64       // last measure of the station
+		outdoor  float64       // last measure of the outdoor module
+		maxAge   time.Duration
+		filter   string
+		fresh    bool
+		stations int
+	}{
+		{
+			name:     "fresh cache",
+			fetched:  time.Minute,
+			station:  ago(2 * time.Minute),
+			outdoor:  ago(3 * time.Minute),
+			maxAge:   uploadInterval,
+			fresh:    true,
+			stations: 1,
+		},
+		{
+			name:    "one module overdue",
+			fetched: time.Minute,
+			station: ago(2 * time.Minute),
+			outdoor: ago(11 * time.Minute),
+			maxAge:  uploadInterval,
+		},
+		{
+			name:     "module without measures is skipped",
+			fetched:  time.Minute,
+			station:  ago(2 * time.Minute),
+			maxAge:   uploadInterval,
+			fresh:    true,
+			stations: 1,
+		},
+		{
+			name:    "no module measured",
+			fetched: time.Minute,
+			maxAge:  uploadInterval,
+		},
+		{
+			name:    "cache older than max cache age",
+			fetched: 5 * time.Minute,
+			station: ago(time.Minute),
+			outdoor: ago(time.Minute),
+			maxAge:  4 * time.Minute,
+		},
+		{
+			name:    "no station matches the filter",
+			fetched: time.Minute,
+			station: ago(2 * time.Minute),
+			outdoor: ago(2 * time.Minute),
+			maxAge:  uploadInterval,
+			filter:  "Office",
+		},
+		{
+			name:   "no cache",
+			maxAge: uploadInterval,
+		},
+	}
+
+	for i, test := range tests {
+		p := &Profile{CacheFile: filepath.Join(dir, fmt.Sprintf("cache-%d.json", i)), Station: test.filter}
+
+		if test.fetched > 0 {
+			outdoor := &netatmo.Device{ID: "02:00:00:00:00:01", ModuleName: "Outdoor", Type: "NAModule1"}
+			outdoor.DashboardData.LastMeasure = test.outdoor
+			station := &netatmo.Device{ID: "70:ee:50:00:00:01", StationName: "Home", ModuleName: "Indoor", Type: "NAMain", LinkedModules: []*netatmo.Device{outdoor}}
+			station.DashboardData.LastMeasure = test.station
+
+			dc := &netatmo.DeviceCollection{}
+			dc.Body.Devices = []*netatmo.Device{station}
+			b, err := json.Marshal(cachedDevices{Fetched: now.Add(-test.fetched), Devices: dc})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := writeFileAtomic(p.CacheFile, b); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		stations, fresh := freshStations(p, test.maxAge)
+		if fresh != test.fresh || len(stations) != test.stations {
+			t.Errorf("%s: got %d stations, fresh %v, want %d, fresh %v", test.name, len(stations), fresh, test.stations, test.fresh)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+	"github.com/dhogborg/atnetgo/stations"
+	netatmo "github.com/dhogborg/netatmo-api-go"
+)
+
+// comparePublic compares the outdoor temperature and humidity and the pressure of the
+// stations with the medians of the public stations within --radius, and exits with
+// exitDrift when a deviation is larger than its threshold. Stations without a location
+// are skipped, the run then exits with exitPartial unless some module drifts.
+func comparePublic(ctx context.Context, c *cli.Context) {
+	p := profile
+
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	printer, err := stations.NewComparisonPrinter(outputFormat(c), p.Units)
+	if err != nil {
+		fatal(ctx, err, profileFields(p), "configuration error")
+	}
+	radius := c.Float64("radius")
+	if radius <= 0 {
+		fatal(ctx, fmt.Errorf("invalid --radius %g, use a distance in km", radius), profileFields(p), "configuration error")
+	}
+	thresholds := map[string]float64{
+		netatmo.NAMainTemperature: c.Float64("temperature-threshold"),
+		netatmo.NAMainHumidity:    c.Float64("humidity-threshold"),
+		netatmo.NAMainPressure:    c.Float64("pressure-threshold"),
+	}
+	minNeighbours := c.Int("min-stations")
+
+	devices, err := fetchStations(ctx, p)
+	if err != nil {
+		fatal(ctx, err, profileFields(p), "unable to read account")
+	}
+	if len(devices) == 0 {
+		fatal(ctx, &netatmo.NotFoundError{APIError: &netatmo.APIError{Message: "no station found"}}, profileFields(p), "unable to find station")
+	}
+
+	config, err := clientConfig(p)
+	if err != nil {
+		fatal(ctx, err, profileFields(p), "configuration error")
+	}
+	n, err := newClient(ctx, p, config)
+	if err != nil {
+		fatal(ctx, err, profileFields(p), "unable to read public data")
+	}
+
+	deviations := []stations.Deviation{}
+	skipped := 0
+	for _, station := range devices {
+		fields := profileFields(p)
+		fields["station"] = station.StationName
+
+		lat, lon, ok := station.Location()
+		if !ok {
+			log.WithFields(fields).Warn("skipping station without location")
+			skipped++
+			continue
+		}
+
+		q := netatmo.PublicQuery{Area: stations.AreaAround(lat, lon, radius), Filter: !c.Bool("no-filter")}
+		found, err := n.ReadPublic(ctx, q)
+		if err != nil {
+			fatal(ctx, err, fields, "unable to read public data")
+		}
+		public := stations.NewPublic(stations.Nearby(station, found, radius))
+
+		for _, dataType := range []string{netatmo.NAMainTemperature, netatmo.NAMainHumidity, netatmo.NAMainPressure} {
+			if count := public.Stats[dataType].Count; count < minNeighbours {
+				log.WithFields(fields).WithFields(log.Fields{
+					"type":       dataType,
+					"neighbours": count,
+				}).Warn("too few public stations around to compare")
+			}
+		}
+		deviations = append(deviations, stations.Compare(station, public, thresholds, minNeighbours)...)
+	}
+
+	if err := printer.PrintComparison(os.Stdout, deviations); err != nil {
+		fatal(ctx, err, profileFields(p), "unable to print")
+	}
+
+	for _, d := range deviations {
+		if d.Drift {
+			os.Exit(exitDrift)
+		}
+	}
+	if skipped > 0 {
+		os.Exit(exitPartial)
+	}
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+	"github.com/dhogborg/atnetgo/stations"
+	netatmo "github.com/dhogborg/netatmo-api-go"
+	"gopkg.in/yaml.v2"
+)
+
+// ConfigFile is the layout of the config file, a set of named profiles
+//
+//	default_profile: home
+//	profiles:
+//	  home:
+//	    token_file: ~/.config/atnetgo/home.json
+//	    station: Home
+//	    command: influx
+//	    units: metric
+//	    output: /var/spool/atnetgo/home.txt
+type ConfigFile struct {
+	DefaultProfile string              `yaml:"default_profile"`
+	Profiles       map[string]*Profile `yaml:"profiles"`
+}
+
+// Profile holds the settings for one account. Every field can be
+// overridden with the global flag or environment variable of the same name.
+// ClientID, ClientSecret : App credentials from dev.netatmo.com
+// ClientSecretFile, ClientSecretCommand : Read the client secret from a file or the output of a command
+// User, Password : Account login, only needed for the password grant
+// PasswordFile, PasswordCommand : Read the password from a file or the output of a command
+// TokenFile : Where the token from login is stored
+// Scopes : OAuth scopes requested at login, default read_station
+// APIURL : Netatmo API url, to use another host, a proxy or a mock
+// RevokeURL : Token revocation endpoint used by auth logout, none by default
+// Timeout : Give up on the API after this long, e.g. 30s
+// RetryAttempts : Attempts per request for transient failures, 1 disables retries
+// RetryBackoff, RetryMaxBackoff : First and longest wait between attempts, doubled in between
+// RetryBudget : Total time a request may take including retries
+// RateLimit : Client side rate limiting, wait (default), fail or off
+// RateLimitFile : State file shared by all processes using the account, default next to the token file
+// Record : Directory every raw API response is saved to, for debugging and fixtures
+// ScrubLocation : Leave the station location out of recorded responses
+// Trace : Log every HTTP request and response, with credentials redacted
+// Replay : Saved getstationsdata response printed instead of asking the API, only as flag
+// AllowStale : Print the values of the last successful read when the API fails
+// CacheFile : Where the values of the last successful read are kept, default next to the token file
+// MaxCacheAge : Longest time cached values are printed instead of asking the API, while no new measures are due
+// NoCache : Always ask the API
+// Station : Station filter
+// Command : Output command used when none is given (pretty, list, json, influx)
+// Units : metric (default) or imperial
+// Output : File the data commands write to instead of stdout
+type Profile struct {
+	Name string `yaml:"-"`
+
+	ClientID     string        `yaml:"client_id"`
+	ClientSecret string        `yaml:"client_secret"`
+	User         string        `yaml:"user"`
+	Password     string        `yaml:"password"`
+	TokenFile    string        `yaml:"token_file"`
+	Scopes       []string      `yaml:"scopes"`
+	APIURL       string        `yaml:"api_url"`
+	RevokeURL    string        `yaml:"revoke_url"`
+	Timeout      time.Duration `yaml:"timeout"`
+
+	RetryAttempts   int           `yaml:"retry_attempts"`
+	RetryBackoff    time.Duration `yaml:"retry_backoff"`
+	RetryMaxBackoff time.Duration `yaml:"retry_max_backoff"`
+	RetryBudget     time.Duration `yaml:"retry_budget"`
+
+	RateLimit     string `yaml:"rate_limit"`
+	RateLimitFile string `yaml:"rate_limit_file"`
+
+	Record        string `yaml:"record"`
+	ScrubLocation bool   `yaml:"scrub_location"`
+	Trace         bool   `yaml:"trace"`
+	Replay        string `yaml:"-"`
+
+	AllowStale  bool          `yaml:"allow_stale"`
+	CacheFile   string        `yaml:"cache_file"`
+	MaxCacheAge time.Duration `yaml:"max_cache_age"`
+	NoCache     bool          `yaml:"no_cache"`
+
+	Station string `yaml:"station"`
+	Command string `yaml:"command"`
+	Units   string `yaml:"units"`
+	Output  string `yaml:"output"`
+
+	ClientSecretFile    string `yaml:"client_secret_file"`
+	ClientSecretCommand string `yaml:"client_secret_command"`
+	PasswordFile        string `yaml:"password_file"`
+	PasswordCommand     string `yaml:"password_command"`
+}
+
+// profiles are the resolved profiles for this run, one per account to read.
+// profile is the first of them, it decides the run wide settings
+// (command, units, output and timeout). Both are set up before any command runs.
+var (
+	profiles = []*Profile{}
+	profile  = &Profile{}
+)
+
+// loadProfiles resolves the profiles for this run, the ones listed with
+// --profiles or else the single one selected with --profile
+func loadProfiles(ctx *cli.Context) ([]*Profile, error) {
+	config, err := loadConfigFile(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	names := splitList(ctx.GlobalString("profiles"))
+	if len(names) == 0 {
+		names = append(names, ctx.GlobalString("profile"))
+	}
+
+	resolved := []*Profile{}
+	tokens := map[string]string{}
+	for _, name := range names {
+		p, err := loadProfile(ctx, config, name)
+		if err != nil {
+			return nil, err
+		}
+
+		// a shared token file would have the accounts overwrite each other's tokens
+		path := filepath.Clean(tokenPath(p))
+		if other, ok := tokens[path]; ok {
+			return nil, fmt.Errorf("profiles %q and %q use the same token file %s", other, p.Name, path)
+		}
+		tokens[path] = p.Name
+
+		resolved = append(resolved, p)
+	}
+	return resolved, nil
+}
+
+// loadProfile resolves the settings of the named profile. Precedence, highest first:
+// command line flags, environment variables, the profile, defaults.
+func loadProfile(ctx *cli.Context, config *ConfigFile, name string) (*Profile, error) {
+	p, err := config.profile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	// flags have the environment applied already, so any value set here wins
+	overrides := map[string]*string{
+		"client-id":       &p.ClientID,
+		"user":            &p.User,
+		"token-file":      &p.TokenFile,
+		"api-url":         &p.APIURL,
+		"station":         &p.Station,
+		"units":           &p.Units,
+		"output":          &p.Output,
+		"rate-limit":      &p.RateLimit,
+		"rate-limit-file": &p.RateLimitFile,
+		"record":          &p.Record,
+		"replay":          &p.Replay,
+		"cache-file":      &p.CacheFile,
+	}
+	for name, field := range overrides {
+		if value := ctx.GlobalString(name); value != "" {
+			*field = value
+		}
+	}
+	if ctx.GlobalBool("scrub-location") {
+		p.ScrubLocation = true
+	}
+	if ctx.GlobalBool("trace") {
+		p.Trace = true
+	}
+	if ctx.GlobalBool("allow-stale") {
+		p.AllowStale = true
+	}
+	if ctx.GlobalBool("no-cache") {
+		p.NoCache = true
+	}
+
+	if scopes := ctx.GlobalString("scopes"); scopes != "" {
+		p.Scopes = splitList(scopes)
+	}
+
+	if ctx.GlobalBool("password-stdin") && ctx.GlobalBool("client-secret-stdin") {
+		return nil, errors.New("only one of the password and the client secret can be read from stdin")
+	}
+	if err := resolveSecret(ctx, "password", &p.Password, &p.PasswordFile, &p.PasswordCommand); err != nil {
+		return nil, err
+	}
+	if err := resolveSecret(ctx, "client-secret", &p.ClientSecret, &p.ClientSecretFile, &p.ClientSecretCommand); err != nil {
+		return nil, err
+	}
+
+	durations := map[string]*time.Duration{
+		"timeout":           &p.Timeout,
+		"retry-backoff":     &p.RetryBackoff,
+		"retry-max-backoff": &p.RetryMaxBackoff,
+		"retry-budget":      &p.RetryBudget,
+		"max-cache-age":     &p.MaxCacheAge,
+	}
+	for name, field := range durations {
+		if value := ctx.GlobalDuration(name); value > 0 {
+			*field = value
+		}
+	}
+	if attempts := ctx.GlobalInt("retry-attempts"); attempts > 0 {
+		p.RetryAttempts = attempts
+	}
+
+	defaults := []struct {
+		value *time.Duration
+		def   time.Duration
+	}{
+		{&p.RetryBackoff, time.Second},
+		{&p.RetryMaxBackoff, 30 * time.Second},
+		{&p.RetryBudget, time.Minute},
+		{&p.MaxCacheAge, uploadInterval},
+	}
+	for _, d := range defaults {
+		if *d.value == 0 {
+			*d.value = d.def
+		}
+	}
+	if p.RetryAttempts == 0 {
+		p.RetryAttempts = 3
+	}
+
+	p.TokenFile = expandHome(p.TokenFile)
+	p.Output = expandHome(p.Output)
+	p.RateLimitFile = expandHome(p.RateLimitFile)
+	p.Record = expandHome(p.Record)
+	p.Replay = expandHome(p.Replay)
+	p.CacheFile = expandHome(p.CacheFile)
+
+	switch p.Units {
+	case "":
+		p.Units = stations.UnitsMetric
+	case stations.UnitsMetric, stations.UnitsImperial:
+	default:
+		return nil, fmt.Errorf("unknown units %q, use %s or %s", p.Units, stations.UnitsMetric, stations.UnitsImperial)
+	}
+
+	switch p.RateLimit {
+	case "":
+		p.RateLimit = rateLimitWait
+	case rateLimitWait, rateLimitFail, rateLimitOff:
+	default:
+		return nil, fmt.Errorf("unknown rate limit mode %q, use %s, %s or %s", p.RateLimit, rateLimitWait, rateLimitFail, rateLimitOff)
+	}
+
+	if len(p.Scopes) == 0 {
+		p.Scopes = netatmo.DefaultScopes
+	}
+	for _, scope := range p.Scopes {
+		if !contains(netatmo.AllScopes, scope) {
+			return nil, fmt.Errorf("unknown scope %q, use %s", scope, strings.Join(netatmo.AllScopes, ", "))
+		}
+	}
+
+	switch p.Command {
+	case "", "pretty", "list", "json", "influx":
+	default:
+		return nil, fmt.Errorf("unknown default command %q", p.Command)
+	}
+
+	return p, nil
+}
+
+// loadConfigFile reads the config file given with --config, or the default
+// one if it exists. A missing default file is the same as an empty one.
+func loadConfigFile(ctx *cli.Context) (*ConfigFile, error) {
+	path := expandHome(ctx.GlobalString("config"))
+	explicit := path != ""
+	if !explicit {
+		path = filepath.Join(configDir(), "config.yaml")
+	}
+
+	config := &ConfigFile{}
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) && !explicit {
+		return config, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := yaml.Unmarshal(b, config); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err.Error())
+	}
+
+	if info, err := os.Stat(path); err == nil && info.Mode().Perm()&0077 != 0 && config.hasSecrets() {
+		log.WithFields(log.Fields{
+			"path": path,
+		}).Warn("config file with secrets is readable by other users")
+	}
+
+	return config, nil
+}
+
+// profile returns a copy of the named profile. Without a name the default
+// profile is used, or an empty one if there is none.
+func (c *ConfigFile) profile(name string) (*Profile, error) {
+	if name == "" {
+		name = c.DefaultProfile
+	}
+	if name == "" {
+		name = "default"
+		if _, ok := c.Profiles[name]; !ok {
+			return &Profile{Name: name}, nil
+		}
+	}
+
+	p, ok := c.Profiles[name]
+	if !ok || p == nil {
+		return nil, fmt.Errorf("no profile named %q in config file", name)
+	}
+
+	resolved := *p
+	resolved.Name = name
+	return &resolved, nil
+}
+
+func (c *ConfigFile) hasSecrets() bool {
+	for _, p := range c.Profiles {
+		if p != nil && (p.Password != "" || p.ClientSecret != "") {
+			return true
+		}
+	}
+	return false
+}
+
+// expandHome replaces a leading ~/ with the home directory
+func expandHome(path string) string {
+	if len(path) > 1 && path[:2] == "~/" {
+		return filepath.Join(os.Getenv("HOME"), path[2:])
+	}
+	return path
+}
+
+// splitList splits a comma separated flag value, leaving out empty items
+func splitList(s string) []string {
+	items := []string{}
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// contains tells if list holds s
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"errors"
+)
+
+// NetatmoAppID and NetatmoAppSecret are optional compiled-in app credentials,
+// used when none are given at runtime. Set them in secrets.go (see secrets.example.go)
+// or with -ldflags "-X main.NetatmoAppID=... -X main.NetatmoAppSecret=..."
+var (
+	NetatmoAppID     string
+	NetatmoAppSecret string
+)
+
+// clientCredentials returns the app client id and secret. The profile
+// takes precedence over the compiled-in values. The id and secret always come
+// from the same place, a runtime id is never paired with a compiled-in secret.
+func clientCredentials(p *Profile) (string, string, error) {
+	id, secret := p.ClientID, p.ClientSecret
+
+	if id == "" && secret == "" {
+		id, secret = NetatmoAppID, NetatmoAppSecret
+		if id == "" {
+			return "", "", errors.New("no client id configured, use --client-id/--client-secret, NETATMO_CLIENT_ID/NETATMO_CLIENT_SECRET or the config file")
+		}
+	}
+
+	if id == "" {
+		return "", "", errors.New("client secret given without a client id")
+	}
+	if secret == "" {
+		return "", "", errors.New("client id given without a client secret")
+	}
+
+	return id, secret, nil
+}
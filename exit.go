@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	log "github.com/Sirupsen/logrus"
+	netatmo "github.com/dhogborg/netatmo-api-go"
+)
+
+// Exit statuses, besides 0 for success
+const (
+	// exitError is a general failure, nothing was printed
+	exitError = 1
+	// exitPartial is when some of the accounts could not be read
+	exitPartial = 2
+	// exitAuth is when the token is invalid, expired or lacks a scope, log in again
+	exitAuth = 3
+	// exitRateLimited is when the request quota of the account or app is used up
+	exitRateLimited = 4
+	// exitNotFound is when the requested device does not exist
+	exitNotFound = 5
+	// exitServer is when the Netatmo API failed on its side
+	exitServer = 6
+	// exitStale is when some values are from the cache because the API failed, see --allow-stale
+	exitStale = 7
+	// exitDrift is when compare-public suspects a module of drifting from its neighbourhood
+	exitDrift = 8
+	// exitTimeout is when --timeout passed before the requests completed
+	exitTimeout = 124
+	// exitInterrupted is when the run was canceled with ctrl-c or SIGTERM
+	exitInterrupted = 130
+)
+
+// interruptContext returns a context that is canceled on ctrl-c or SIGTERM,
+// in-flight requests are then aborted. A second signal exits right away.
+func interruptContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		s := <-signals
+		log.WithFields(log.Fields{
+			"signal": s.String(),
+		}).Warn("canceling requests")
+		cancel()
+
+		<-signals
+		os.Exit(exitInterrupted)
+	}()
+
+	return ctx
+}
+
+// exitStatus returns the exit status for the class of err
+func exitStatus(err error) int {
+	switch err.(type) {
+	case *netatmo.AuthError:
+		return exitAuth
+	case *netatmo.RateLimitError:
+		return exitRateLimited
+	case *netatmo.NotFoundError:
+		return exitNotFound
+	case *netatmo.ServerError:
+		return exitServer
+	}
+	return exitError
+}
+
+// withTimeout returns ctx limited to the timeout of the profile, if it has one.
+// Call cancel once the requests are done.
+func withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if profile.Timeout > 0 {
+		return context.WithTimeout(ctx, profile.Timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// fatal logs err with fields and exits, with the status of ctx if it was
+// canceled or timed out and otherwise with the status for the class of err
+func fatal(ctx context.Context, err error, fields log.Fields, msg string) {
+	log.WithFields(errorFields(err, fields)).Error(msg)
+	exitIfDone(ctx)
+	os.Exit(exitStatus(err))
+}
+
+// exitIfDone exits if ctx was canceled or timed out, with the matching status
+func exitIfDone(ctx context.Context) {
+	switch ctx.Err() {
+	case context.Canceled:
+		os.Exit(exitInterrupted)
+	case context.DeadlineExceeded:
+		os.Exit(exitTimeout)
+	}
+}
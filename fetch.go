@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/dhogborg/atnetgo/stations"
+	netatmo "github.com/dhogborg/netatmo-api-go"
+)
+
+// getDevices reads the stations of every account concurrently. Accounts that
+// fail are logged and listed in Failed, it only exits if no account could be read,
+// with the status matching the error of the first account, or if the requests
+// were canceled. With --allow-stale failed accounts are served from the cache
+// of their last successful read instead, and listed in Stale.
+func getDevices(ctx context.Context) *stations.DeviceCollection {
+
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	type result struct {
+		stations []*netatmo.Device
+		err      error
+	}
+
+	results := make([]result, len(profiles))
+
+	wg := sync.WaitGroup{}
+	for i, p := range profiles {
+		wg.Add(1)
+		go func(i int, p *Profile) {
+			defer wg.Done()
+			results[i].stations, results[i].err = fetchStations(ctx, p)
+		}(i, p)
+	}
+	wg.Wait()
+
+	var firstErr error
+
+	collection := &stations.DeviceCollection{}
+	for i, p := range profiles {
+		collection.Accounts = append(collection.Accounts, p.Name)
+
+		if err := results[i].err; err != nil {
+			// an interrupted run stops, a timeout is just another failure
+			if p.AllowStale && ctx.Err() != context.Canceled {
+				cached, fetched, serr := staleStations(p)
+				if serr == nil {
+					fields := errorFields(err, profileFields(p))
+					fields["age"] = staleAge(time.Since(fetched))
+					log.WithFields(fields).Warn("unable to read account, using cached values")
+					collection.AddStale(p.Name, cached, fetched)
+					continue
+				}
+				log.WithFields(errorFields(serr, profileFields(p))).Warn("no cached values")
+			}
+
+			log.WithFields(errorFields(err, profileFields(p))).Error("unable to read account")
+			collection.Failed = append(collection.Failed, p.Name)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		collection.Add(p.Name, results[i].stations)
+	}
+
+	if len(collection.Failed) > 0 {
+		exitIfDone(ctx)
+	}
+	if len(collection.Failed) == len(profiles) {
+		os.Exit(exitStatus(firstErr))
+	}
+
+	return collection
+}
+
+// fetchStations reads the stations of one account, filtered by the station filter of the profile
+func fetchStations(ctx context.Context, p *Profile) ([]*netatmo.Device, error) {
+	if p.Replay != "" {
+		dc, err := replayDevices(p.Replay)
+		if err != nil {
+			return nil, err
+		}
+		return stations.Filter(p.Station, dc).Stations(), nil
+	}
+
+	if !p.NoCache {
+		if fresh, ok := freshStations(p, p.MaxCacheAge); ok {
+			log.WithFields(log.Fields{
+				"account": p.Name,
+				"path":    cachePath(p),
+			}).Debug("cached values are fresh, skipping the API")
+			return fresh, nil
+		}
+	}
+
+	config, err := clientConfig(p)
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := newClient(ctx, p, config)
+	if err != nil {
+		return nil, err
+	}
+
+	dc, err := n.Read(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// the cache is only needed for --allow-stale, but kept up to date
+	// regardless so it is there when the API goes down
+	if err := saveDevices(p, dc); err != nil {
+		fields := errorFields(err, profileFields(p))
+		fields["path"] = cachePath(p)
+		log.WithFields(fields).Warn("unable to cache devices")
+	}
+
+	return stations.Filter(p.Station, dc).Stations(), nil
+}
+
+// exitOnFailure exits with exitPartial if some accounts could not be read,
+// or with exitStale if they were all served, some from the cache
+func exitOnFailure(d *stations.DeviceCollection) {
+	if len(d.Failed) > 0 {
+		os.Exit(exitPartial)
+	}
+	if len(d.Stale) > 0 {
+		os.Exit(exitStale)
+	}
+}
+
+// clientConfig collects the app credentials and account login from the profile
+func clientConfig(p *Profile) (netatmo.Config, error) {
+	id, secret, err := clientCredentials(p)
+	if err != nil {
+		return netatmo.Config{}, err
+	}
+
+	return netatmo.Config{
+		ClientID:     id,
+		ClientSecret: secret,
+		Username:     p.User,
+		Password:     p.Password,
+		Scopes:       p.Scopes,
+		BaseURL:      p.APIURL,
+		HTTPClient:   httpClient(p),
+		Limiter:      newLimiter(p),
+		Retry: netatmo.RetryPolicy{
+			MaxAttempts:    p.RetryAttempts,
+			InitialBackoff: p.RetryBackoff,
+			MaxBackoff:     p.RetryMaxBackoff,
+			Budget:         p.RetryBudget,
+			OnRetry: func(attempt int, wait time.Duration, err error) {
+				fields := errorFields(err, profileFields(p))
+				fields["attempt"] = attempt
+				fields["wait"] = wait.String()
+				log.WithFields(fields).Warn("request failed, retrying")
+			},
+		},
+	}, nil
+}
+
+// httpClient returns the http client for the requests of the profile, saving
+// responses with --record and logging them with --trace. nil for the default client.
+func httpClient(p *Profile) *http.Client {
+	if p.Record == "" && !p.Trace {
+		return nil
+	}
+
+	transport := http.DefaultTransport
+	if p.Record != "" {
+		transport = &recordingTransport{
+			dir:           p.Record,
+			account:       p.Name,
+			scrubLocation: p.ScrubLocation,
+			next:          transport,
+		}
+	}
+	if p.Trace {
+		transport = &tracingTransport{
+			account: p.Name,
+			next:    transport,
+		}
+	}
+	return &http.Client{Transport: transport}
+}
+
+// newClient creates a client from the cached token, refreshing it and writing
+// it back as needed. The password grant is only used to fill an empty cache
+// when a user name is given.
+func newClient(ctx context.Context, p *Profile, config netatmo.Config) (*netatmo.Client, error) {
+	path := tokenPath(p)
+	oauth := netatmo.OAuthConfig(config)
+
+	token, err := loadToken(path)
+	if os.IsNotExist(err) {
+		if config.Username == "" {
+			return nil, errNotLoggedIn()
+		}
+
+		token, err = netatmo.PasswordToken(ctx, config)
+		if err != nil {
+			return nil, err
+		}
+		if err := saveToken(path, token); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	src := newCachedTokenSource(path, token, oauth.TokenSource(netatmo.OAuthContext(ctx, config), token))
+	return netatmo.NewClientWithTokenSource(ctx, config, src), nil
+}
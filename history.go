@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codegangsta/cli"
+	"github.com/dhogborg/atnetgo/stations"
+	netatmo "github.com/dhogborg/netatmo-api-go"
+)
+
+// history prints the measures of one module read with getmeasure, in the format
+// given with --format. The station is picked with --station, the module with --module.
+func history(ctx context.Context, c *cli.Context) {
+	p := profile
+
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	printer, err := stations.NewHistoryPrinter(outputFormat(c), p.Units)
+	if err != nil {
+		fatal(ctx, err, profileFields(p), "configuration error")
+	}
+	q, err := historyQuery(c, time.Now())
+	if err != nil {
+		fatal(ctx, err, profileFields(p), "configuration error")
+	}
+
+	devices, err := fetchStations(ctx, p)
+	if err != nil {
+		fatal(ctx, err, profileFields(p), "unable to read account")
+	}
+	station, module, err := findModule(devices, c.String("module"))
+	if err != nil {
+		fatal(ctx, err, profileFields(p), "unable to find module")
+	}
+
+	q.DeviceID = station.ID
+	if module != station {
+		q.ModuleID = module.ID
+	}
+	if len(q.Types) == 0 {
+		q.Types = netatmo.MeasureTypes[module.Type]
+	}
+
+	config, err := clientConfig(p)
+	if err != nil {
+		fatal(ctx, err, profileFields(p), "configuration error")
+	}
+	n, err := newClient(ctx, p, config)
+	if err != nil {
+		fatal(ctx, err, profileFields(p), "unable to read history")
+	}
+	measures, err := n.ReadMeasures(ctx, q)
+	if err != nil {
+		fatal(ctx, err, profileFields(p), "unable to read history")
+	}
+
+	h := &stations.History{
+		Station:  station.StationName,
+		Module:   module.ModuleName,
+		Types:    q.Types,
+		Measures: measures,
+	}
+	if err := printer.PrintHistory(os.Stdout, h); err != nil {
+		fatal(ctx, err, profileFields(p), "unable to print")
+	}
+}
+
+// outputFormat returns the format given with --format, else the default command
+// of the profile when it is a format, else list
+func outputFormat(c *cli.Context) string {
+	if format := c.String("format"); format != "" {
+		return format
+	}
+	switch profile.Command {
+	case stations.FormatList, stations.FormatJSON, stations.FormatPretty, stations.FormatInflux:
+		return profile.Command
+	}
+	return stations.FormatList
+}
+
+// historyQuery reads the scale, types and time range of the history command.
+// Relative times are taken back from now.
+func historyQuery(c *cli.Context, now time.Time) (netatmo.MeasureQuery, error) {
+	q := netatmo.MeasureQuery{Scale: c.String("scale")}
+	if q.Scale == "" {
+		q.Scale = netatmo.Scale30Min
+	}
+	if !contains(netatmo.Scales, q.Scale) {
+		return q, fmt.Errorf("unknown scale %q, use %s", q.Scale, strings.Join(netatmo.Scales, ", "))
+	}
+
+	for _, t := range splitList(c.String("type")) {
+		q.Types = append(q.Types, measureType(t))
+	}
+
+	var err error
+	if q.Begin, err = parseTime(c.String("begin"), now); err != nil {
+		return q, fmt.Errorf("invalid --begin: %s", err.Error())
+	}
+	if q.End, err = parseTime(c.String("end"), now); err != nil {
+		return q, fmt.Errorf("invalid --end: %s", err.Error())
+	}
+	if !q.Begin.IsZero() && !q.End.IsZero() && q.End.Before(q.Begin) {
+		return q, errors.New("--end is before --begin")
+	}
+	return q, nil
+}
+
+// measureType returns the known measure type t names in any case, t itself if unknown
+func measureType(t string) string {
+	for _, types := range netatmo.MeasureTypes {
+		for _, known := range types {
+			if strings.EqualFold(t, known) {
+				return known
+			}
+		}
+	}
+	return t
+}
+
+// relativeTime matches times relative to now, e.g. 90m, 24h, 7d or 2w, optionally with a leading minus
+var relativeTime = regexp.MustCompile(`^-?([0-9]+)([dw])$`)
+
+// timeLayouts are the absolute times understood by parseTime, in local time unless a zone is given
+var timeLayouts = []string{time.RFC3339, "2006-01-02T15:04", "2006-01-02 15:04", "2006-01-02"}
+
+// parseTime parses s as now, a time relative to now (24h, 7d), a unix timestamp or a
+// date and time, see timeLayouts. Empty is the zero time.
+func parseTime(s string, now time.Time) (time.Time, error) {
+	switch {
+	case s == "":
+		return time.Time{}, nil
+	case s == "now":
+		return now, nil
+	}
+
+	if m := relativeTime.FindStringSubmatch(s); m != nil {
+		n, _ := strconv.Atoi(m[1])
+		days := map[string]int{"d": 1, "w": 7}[m[2]] * n
+		return now.AddDate(0, 0, -days), nil
+	}
+	if d, err := time.ParseDuration(strings.TrimPrefix(s, "-")); err == nil {
+		return now.Add(-d), nil
+	}
+	if seconds, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unknown time %q, use e.g. 24h, 7d, 2006-01-02, 2006-01-02T15:04 or a unix timestamp", s)
+}
+
+// findModule returns the one station in devices and its module named name,
+// or the station itself for an empty name. Exact names win over partial ones.
+func findModule(devices []*netatmo.Device, name string) (station, module *netatmo.Device, err error) {
+	switch len(devices) {
+	case 0:
+		return nil, nil, &netatmo.NotFoundError{APIError: &netatmo.APIError{Message: "no station found"}}
+	case 1:
+		station = devices[0]
+	default:
+		names := []string{}
+		for _, d := range devices {
+			names = append(names, d.StationName)
+		}
+		return nil, nil, fmt.Errorf("several stations found, pick one with --station: %s", strings.Join(names, ", "))
+	}
+
+	if name == "" {
+		return station, station, nil
+	}
+
+	partial := []*netatmo.Device{}
+	for _, m := range station.Modules() {
+		if m.ModuleName == name {
+			return station, m, nil
+		}
+		if strings.Contains(m.ModuleName, name) {
+			partial = append(partial, m)
+		}
+	}
+	switch len(partial) {
+	case 0:
+		return nil, nil, &netatmo.NotFoundError{APIError: &netatmo.APIError{Message: fmt.Sprintf("no module %q on %s", name, station.StationName)}}
+	case 1:
+		return station, partial[0], nil
+	}
+
+	names := []string{}
+	for _, m := range partial {
+		names = append(names, m.ModuleName)
+	}
+	return nil, nil, fmt.Errorf("several modules match %q, use one of %s", name, strings.Join(names, ", "))
+}
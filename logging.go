@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+	netatmo "github.com/dhogborg/netatmo-api-go"
+)
+
+// Log formats
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// Error classes, logged as error_class with every error
+const (
+	errorClassAuth        = "auth"
+	errorClassRateLimited = "rate_limited"
+	errorClassNotFound    = "not_found"
+	errorClassServer      = "server"
+	errorClassAPI         = "api"
+	errorClassTimeout     = "timeout"
+	errorClassCanceled    = "canceled"
+	errorClassNetwork     = "network"
+	errorClassOther       = "other"
+)
+
+// setupLogging applies --log-level, --log-format and --log-file. Logs go to stderr
+// by default, stdout is left to the output.
+func setupLogging(c *cli.Context) error {
+	level, err := log.ParseLevel(c.GlobalString("log-level"))
+	if err != nil {
+		return fmt.Errorf("unknown log level %q, use debug, info, warning or error", c.GlobalString("log-level"))
+	}
+
+	out := os.Stderr
+	if path := c.GlobalString("log-file"); path != "" {
+		out, err = os.OpenFile(expandHome(path), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			return err
+		}
+	}
+
+	switch format := c.GlobalString("log-format"); format {
+	case logFormatText:
+		log.SetFormatter(&log.TextFormatter{DisableColors: out != os.Stderr})
+	case logFormatJSON:
+		log.SetFormatter(&log.JSONFormatter{})
+	default:
+		return fmt.Errorf("unknown log format %q, use %s or %s", format, logFormatText, logFormatJSON)
+	}
+
+	log.SetLevel(level)
+	log.SetOutput(out)
+	return nil
+}
+
+// commandName returns the command run, with the subcommand for auth,
+// or the default command of the profile when none is given
+func commandName(c *cli.Context) string {
+	args := c.Args()
+	if len(args) == 0 {
+		if profile.Command != "" {
+			return profile.Command
+		}
+		return "list"
+	}
+	if args[0] == "auth" && len(args) > 1 {
+		return args[0] + " " + args[1]
+	}
+	return args[0]
+}
+
+// fieldHook adds its fields to every log entry that doesn't set them itself
+type fieldHook log.Fields
+
+func (h fieldHook) Levels() []log.Level {
+	return []log.Level{log.PanicLevel, log.FatalLevel, log.ErrorLevel, log.WarnLevel, log.InfoLevel, log.DebugLevel}
+}
+
+func (h fieldHook) Fire(entry *log.Entry) error {
+	for key, value := range h {
+		if _, ok := entry.Data[key]; !ok {
+			entry.Data[key] = value
+		}
+	}
+	return nil
+}
+
+// profileFields returns the fields identifying the account of a log entry,
+// the station filter included when there is one
+func profileFields(p *Profile) log.Fields {
+	fields := log.Fields{"account": p.Name}
+	if p.Station != "" {
+		fields["station"] = p.Station
+	}
+	return fields
+}
+
+// errorFields adds err to fields, with its class and, when known, the
+// endpoint that failed and after how many attempts
+func errorFields(err error, fields log.Fields) log.Fields {
+	if fields == nil {
+		fields = log.Fields{}
+	}
+	fields["error"] = redact(err.Error())
+	fields["error_class"] = errorClass(err)
+
+	if e := netatmo.AsAPIError(err); e != nil {
+		if e.Endpoint != "" {
+			fields["endpoint"] = e.Endpoint
+		}
+		if e.Attempts > 0 {
+			fields["attempt"] = e.Attempts
+		}
+	}
+	if uerr, ok := err.(*url.Error); ok {
+		if u, perr := url.Parse(uerr.URL); perr == nil {
+			fields["endpoint"] = strings.TrimPrefix(u.Path, "/")
+		}
+	}
+	return fields
+}
+
+// errorClass returns the class of err for log collectors, in line with the exit status
+func errorClass(err error) string {
+	switch err.(type) {
+	case *netatmo.AuthError:
+		return errorClassAuth
+	case *netatmo.RateLimitError:
+		return errorClassRateLimited
+	case *netatmo.NotFoundError:
+		return errorClassNotFound
+	case *netatmo.ServerError:
+		return errorClassServer
+	case *netatmo.APIError:
+		return errorClassAPI
+	}
+
+	if uerr, ok := err.(*url.Error); ok {
+		err = uerr.Err
+	}
+	switch err {
+	case context.DeadlineExceeded:
+		return errorClassTimeout
+	case context.Canceled:
+		return errorClassCanceled
+	}
+	if nerr, ok := err.(net.Error); ok {
+		if nerr.Timeout() {
+			return errorClassTimeout
+		}
+		return errorClassNetwork
+	}
+	return errorClassOther
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"runtime"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+	netatmo "github.com/dhogborg/netatmo-api-go"
+)
+
+// callbackResult is what the redirect handler received from the browser
+type callbackResult struct {
+	code string
+	err  error
+}
+
+// login runs the oauth2 authorization code flow and stores the resulting token
+// The wait for the browser and the code exchange are aborted when ctx is canceled.
+func login(ctx context.Context, c *cli.Context) {
+
+	redirect, err := url.Parse(c.String("redirect-uri"))
+	if err != nil || redirect.Host == "" {
+		log.WithFields(log.Fields{
+			"redirect-uri": c.String("redirect-uri"),
+		}).Error("invalid redirect uri")
+		os.Exit(exitError)
+	}
+
+	state, err := randomState()
+	if err != nil {
+		fatal(ctx, err, nil, "unable to create state")
+	}
+
+	config, err := clientConfig(profile)
+	if err != nil {
+		fatal(ctx, err, nil, "configuration error")
+	}
+	config.RedirectURL = redirect.String()
+
+	oauth := netatmo.OAuthConfig(config)
+
+	listener, err := net.Listen("tcp", redirect.Host)
+	if err != nil {
+		fatal(ctx, err, nil, "unable to listen for the redirect")
+	}
+
+	authURL := oauth.AuthCodeURL(state)
+	fmt.Printf("Open the following URL in a browser to authorize atnetgo:\n\n%s\n\n", authURL)
+	if !c.Bool("no-browser") {
+		openBrowser(authURL)
+	}
+
+	code, err := awaitCode(ctx, listener, redirect.Path, state, c.Duration("wait"))
+	if err != nil {
+		fatal(ctx, err, nil, "authorization failed")
+	}
+
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	token, err := oauth.Exchange(netatmo.OAuthContext(ctx, config), code)
+	if err != nil {
+		fatal(ctx, err, nil, "unable to exchange code for token")
+	}
+
+	// the account owner may have declined some of the scopes
+	if granted := netatmo.TokenScopes(token); granted != nil {
+		for _, scope := range oauth.Scopes {
+			if !contains(granted, scope) {
+				log.WithFields(log.Fields{
+					"scope": scope,
+				}).Warn("scope not granted")
+			}
+		}
+	}
+
+	path := tokenPath(profile)
+	if err := saveToken(path, token); err != nil {
+		fatal(ctx, err, log.Fields{
+			"path": path,
+		}, "unable to store token")
+	}
+
+	fmt.Printf("Logged in, token stored in %s\n", path)
+}
+
+// awaitCode serves the redirect uri on listener until the browser
+// delivers an authorization code, the timeout passes or ctx is canceled
+func awaitCode(ctx context.Context, listener net.Listener, path, state string, timeout time.Duration) (string, error) {
+	defer listener.Close()
+
+	if path == "" {
+		path = "/"
+	}
+
+	results := make(chan callbackResult, 1)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		res := callbackResult{}
+		switch {
+		case query.Get("error") != "":
+			res.err = fmt.Errorf("authorization denied: %s", query.Get("error"))
+		case query.Get("state") != state:
+			res.err = errors.New("state mismatch in redirect")
+		case query.Get("code") == "":
+			res.err = errors.New("no code in redirect")
+		default:
+			res.code = query.Get("code")
+		}
+
+		if res.err != nil {
+			http.Error(w, res.err.Error(), http.StatusBadRequest)
+		} else {
+			fmt.Fprintln(w, "atnetgo is authorized, you may close this window.")
+		}
+
+		select {
+		case results <- res:
+		default:
+		}
+	})
+
+	go http.Serve(listener, mux)
+
+	select {
+	case res := <-results:
+		return res.code, res.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case <-time.After(timeout):
+		return "", fmt.Errorf("no redirect received within %s", timeout)
+	}
+}
+
+// randomState returns an unguessable state parameter
+func randomState() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// openBrowser tries to open url in the default browser, failures are ignored
+// since the url is printed as well
+func openBrowser(url string) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", url)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+	default:
+		cmd = exec.Command("xdg-open", url)
+	}
+
+	if err := cmd.Start(); err != nil {
+		log.WithFields(errorFields(err, nil)).Debug("unable to open browser")
+	}
+}
@@ -1,23 +1,18 @@
 package main
 
 import (
-	"encoding/json"
-	"fmt"
 	"os"
 	"strings"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/codegangsta/cli"
+	"github.com/dhogborg/atnetgo/stations"
 	netatmo "github.com/dhogborg/netatmo-api-go"
 )
 
-// DeviceCollection contains filterd device collection
-type DeviceCollection struct {
-	NetatmoStations []*netatmo.Device
-	Modules         []*netatmo.Device
-}
-
-func (d *DeviceCollection) Stations() []*netatmo.Device { return d.NetatmoStations }
+// dataCommands are the commands whose output goes to the profile's output file
+var dataCommands = []string{"pretty", "list", "json", "influx", "history", "backfill", "public", "compare-public"}
 
 func main() {
 	app := cli.NewApp()
@@ -27,9 +22,41 @@ func main() {
 	app.Author = "github.com/dhogborg"
 	app.Email = "d@hogborg.se"
 
+	ctx := interruptContext()
+
+	app.Before = func(c *cli.Context) error {
+		if err := setupLogging(c); err != nil {
+			return err
+		}
+
+		p, err := loadProfiles(c)
+		if err != nil {
+			return err
+		}
+		profiles, profile = p, p[0]
+
+		// every log entry tells which command it is from
+		log.AddHook(fieldHook{"command": commandName(c)})
+
+		// login, auth and the mock server talk to the user, only data goes to the file
+		if profile.Output != "" && contains(dataCommands, commandName(c)) {
+			f, err := os.Create(profile.Output)
+			if err != nil {
+				return err
+			}
+			os.Stdout = f
+		}
+		return nil
+	}
+
 	app.Action = func(c *cli.Context) {
-		d := getDevices(c)
-		listPrint(d)
+		// the profile may name another command as default, list when it names
+		// none. An empty name would match every command without a short name.
+		command := profile.Command
+		if command == "" {
+			command = "list"
+		}
+		c.App.Command(command).Action(c)
 	}
 
 	app.Commands = []cli.Command{
@@ -37,37 +64,311 @@ func main() {
 			Name:  "pretty",
 			Usage: "Pretty print the stations and the modules attached",
 			Action: func(c *cli.Context) {
-				d := getDevices(c)
-				prettyPrint(d)
+				d := getDevices(ctx)
+				printDevices(stations.FormatPretty, d)
+				exitOnFailure(d)
 			},
 		},
 		cli.Command{
 			Name:  "list",
 			Usage: "List the modules and the values in a greppable list",
 			Action: func(c *cli.Context) {
-				d := getDevices(c)
-				listPrint(d)
+				d := getDevices(ctx)
+				printDevices(stations.FormatList, d)
+				exitOnFailure(d)
 			},
 		},
 		cli.Command{
 			Name:  "json",
 			Usage: "Output a machine readable json string",
 			Action: func(c *cli.Context) {
-				d := getDevices(c)
-				jsonPrint(d)
+				d := getDevices(ctx)
+				printDevices(stations.FormatJSON, d)
+				exitOnFailure(d)
 			},
 		},
 		cli.Command{
 			Name:  "influx",
 			Usage: "Output InfluxDB line format",
 			Action: func(c *cli.Context) {
-				d := getDevices(c)
-				linePrint(d)
+				d := getDevices(ctx)
+				printDevices(stations.FormatInflux, d)
+				exitOnFailure(d)
+			},
+		},
+		cli.Command{
+			Name:  "history",
+			Usage: "Output the measures of a module over time",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "module,m",
+					Usage: "Module name or part of it, default the station itself",
+				},
+				cli.StringFlag{
+					Name:  "type,t",
+					Usage: "Comma separated measure types, default all of the module",
+				},
+				cli.StringFlag{
+					Name:  "scale",
+					Value: netatmo.Scale30Min,
+					Usage: "Interval of the measures: " + strings.Join(netatmo.Scales, ", "),
+				},
+				cli.StringFlag{
+					Name:  "begin",
+					Value: "24h",
+					Usage: "Start of the range, relative (90m, 24h, 7d, 2w) or absolute (2006-01-02, 2006-01-02T15:04, RFC3339, unix time)",
+				},
+				cli.StringFlag{
+					Name:  "end",
+					Value: "now",
+					Usage: "End of the range, like --begin",
+				},
+				cli.StringFlag{
+					Name:  "format,f",
+					Usage: "Output format: list, json, pretty or influx, default the profile command or list",
+				},
+			},
+			Action: func(c *cli.Context) {
+				history(ctx, c)
+			},
+		},
+		cli.Command{
+			Name:  "backfill",
+			Usage: "Output the history of every module in InfluxDB line format, resuming where the last run stopped",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "module,m",
+					Usage: "Only modules with this in their name, default all of them",
+				},
+				cli.StringFlag{
+					Name:  "type,t",
+					Usage: "Comma separated measure types, default all of each module",
+				},
+				cli.StringFlag{
+					Name:  "scale",
+					Value: netatmo.ScaleMax,
+					Usage: "Interval of the measures: " + strings.Join(netatmo.Scales, ", "),
+				},
+				cli.StringFlag{
+					Name:  "begin",
+					Usage: "Start of the range, like history --begin. Default when the module was set up",
+				},
+				cli.StringFlag{
+					Name:  "end",
+					Value: "now",
+					Usage: "End of the range, like history --end",
+				},
+				cli.StringFlag{
+					Name:   "influx-url",
+					Usage:  "Post to this InfluxDB write endpoint instead of stdout, e.g. http://localhost:8086/write?db=weather",
+					EnvVar: "ATNETGO_INFLUX_URL",
+				},
+				cli.StringFlag{
+					Name:   "influx-token",
+					Usage:  "InfluxDB API token, for InfluxDB 2 write endpoints",
+					EnvVar: "ATNETGO_INFLUX_TOKEN",
+				},
+				cli.StringFlag{
+					Name:   "checkpoint-dir",
+					Usage:  "Where the progress of each module is kept, default next to the token file",
+					EnvVar: "ATNETGO_CHECKPOINT_DIR",
+				},
+				cli.BoolFlag{
+					Name:  "restart",
+					Usage: "Ignore the checkpoints and start over from --begin",
+				},
+			},
+			Action: func(c *cli.Context) {
+				backfill(ctx, c)
+			},
+		},
+		cli.Command{
+			Name:  "public",
+			Usage: "Output the community stations in an area with statistics of their values",
+			Flags: []cli.Flag{
+				cli.Float64Flag{
+					Name:  "ne-lat",
+					Usage: "Latitude of the north east corner of the area",
+				},
+				cli.Float64Flag{
+					Name:  "ne-lon",
+					Usage: "Longitude of the north east corner of the area",
+				},
+				cli.Float64Flag{
+					Name:  "sw-lat",
+					Usage: "Latitude of the south west corner of the area",
+				},
+				cli.Float64Flag{
+					Name:  "sw-lon",
+					Usage: "Longitude of the south west corner of the area",
+				},
+				cli.StringFlag{
+					Name:  "required-data",
+					Usage: "Comma separated data the stations must have: " + strings.Join(netatmo.PublicData, ", "),
+				},
+				cli.BoolFlag{
+					Name:  "filter",
+					Usage: "Leave out stations with abnormal values",
+				},
+				cli.StringFlag{
+					Name:  "format,f",
+					Usage: "Output format: list, json, pretty or influx, default the profile command or list",
+				},
+			},
+			Action: func(c *cli.Context) {
+				public(ctx, c)
+			},
+		},
+		cli.Command{
+			Name:  "compare-public",
+			Usage: "Compare the outdoor values and pressure of the stations with the public stations around them",
+			Flags: []cli.Flag{
+				cli.Float64Flag{
+					Name:  "radius",
+					Value: 5,
+					Usage: "Compare with the public stations within this many km",
+				},
+				cli.Float64Flag{
+					Name:  "temperature-threshold",
+					Value: 2,
+					Usage: "Largest temperature deviation from the median in °C that is not suspected drift",
+				},
+				cli.Float64Flag{
+					Name:  "humidity-threshold",
+					Value: 10,
+					Usage: "Largest humidity deviation from the median in % that is not suspected drift",
+				},
+				cli.Float64Flag{
+					Name:  "pressure-threshold",
+					Value: 3,
+					Usage: "Largest pressure deviation from the median in mbar that is not suspected drift",
+				},
+				cli.IntFlag{
+					Name:  "min-stations",
+					Value: 3,
+					Usage: "Fewest public stations with a value to compare it",
+				},
+				cli.BoolFlag{
+					Name:  "no-filter",
+					Usage: "Keep the public stations Netatmo finds abnormal",
+				},
+				cli.StringFlag{
+					Name:  "format,f",
+					Usage: "Output format: list, json, pretty or influx, default the profile command or list",
+				},
+			},
+			Action: func(c *cli.Context) {
+				comparePublic(ctx, c)
+			},
+		},
+		cli.Command{
+			Name:  "login",
+			Usage: "Authorize atnetgo with your Netatmo account and store the token",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "redirect-uri",
+					Value: "http://localhost:8726/callback",
+					Usage: "Redirect URI registered for the app, atnetgo listens on its host and port",
+				},
+				cli.DurationFlag{
+					Name:  "wait",
+					Value: 5 * time.Minute,
+					Usage: "How long to wait for the authorization in the browser",
+				},
+				cli.BoolFlag{
+					Name:  "no-browser",
+					Usage: "Only print the authorization URL, do not open a browser",
+				},
+			},
+			Action: func(c *cli.Context) {
+				login(ctx, c)
+			},
+		},
+		cli.Command{
+			Name:  "mock-server",
+			Usage: "Serve a local emulation of the Netatmo API, for development without an account",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "listen",
+					Value: "127.0.0.1:8727",
+					Usage: "Address to listen on",
+				},
+				cli.StringFlag{
+					Name:  "fixtures",
+					Usage: "JSON file with the stations, measures and failures to serve, default a demo station",
+				},
+				cli.DurationFlag{
+					Name:  "delay",
+					Usage: "Delay every response by this long, to emulate a slow API",
+				},
+				cli.DurationFlag{
+					Name:  "token-lifetime",
+					Usage: "Lifetime of the issued access tokens, default 3h",
+				},
+			},
+			Action: func(c *cli.Context) {
+				mockServer(ctx, c)
+			},
+		},
+		cli.Command{
+			Name:  "auth",
+			Usage: "Show or end the login of the profiles",
+			Subcommands: []cli.Command{
+				cli.Command{
+					Name:  "status",
+					Usage: "Show the account, scopes and expiry of the stored token and check it against the API",
+					Flags: []cli.Flag{
+						cli.BoolFlag{
+							Name:  "json",
+							Usage: "Output a machine readable json string",
+						},
+						cli.BoolFlag{
+							Name:  "offline",
+							Usage: "Only read the token file, do not check the token against the API",
+						},
+					},
+					Action: func(c *cli.Context) {
+						authStatusCommand(ctx, c)
+					},
+				},
+				cli.Command{
+					Name:  "logout",
+					Usage: "Revoke the token where a revocation endpoint is configured and delete the token file",
+					Flags: []cli.Flag{
+						cli.BoolFlag{
+							Name:  "json",
+							Usage: "Output a machine readable json string",
+						},
+						cli.StringFlag{
+							Name:   "revoke-url",
+							Usage:  "Token revocation endpoint (RFC 7009), default none",
+							EnvVar: "NETATMO_REVOKE_URL",
+						},
+					},
+					Action: func(c *cli.Context) {
+						authLogoutCommand(ctx, c)
+					},
+				},
 			},
 		},
 	}
 
 	app.Flags = []cli.Flag{
+		cli.StringFlag{
+			Name:   "config",
+			Usage:  "Config file with profiles, default ~/.config/atnetgo/config.yaml",
+			EnvVar: "ATNETGO_CONFIG",
+		},
+		cli.StringFlag{
+			Name:   "profile",
+			Usage:  "Profile from the config file to use, default to default_profile",
+			EnvVar: "ATNETGO_PROFILE",
+		},
+		cli.StringFlag{
+			Name:   "profiles",
+			Usage:  "Comma separated profiles to read, to combine several accounts in one output",
+			EnvVar: "ATNETGO_PROFILES",
+		},
 		cli.StringFlag{
 			Name:   "user,u",
 			Usage:  "Netatmo login name",
@@ -83,154 +384,175 @@ func main() {
 			Usage:  "A station filter, default to none (print everything)",
 			EnvVar: "NETATMO_STATION",
 		},
+		cli.StringFlag{
+			Name:   "password-file",
+			Usage:  "Read the Netatmo password from the first line of this file",
+			EnvVar: "NETATMO_PASSWORD_FILE",
+		},
+		cli.BoolFlag{
+			Name:  "password-stdin",
+			Usage: "Read the Netatmo password from stdin",
+		},
+		cli.StringFlag{
+			Name:   "password-command",
+			Usage:  "Run this command and read the Netatmo password from its output, e.g. 'pass show netatmo'",
+			EnvVar: "NETATMO_PASSWORD_COMMAND",
+		},
+		cli.StringFlag{
+			Name:   "client-id",
+			Usage:  "Client ID of your app from dev.netatmo.com",
+			EnvVar: "NETATMO_CLIENT_ID",
+		},
+		cli.StringFlag{
+			Name:   "client-secret",
+			Usage:  "Client secret of your app from dev.netatmo.com",
+			EnvVar: "NETATMO_CLIENT_SECRET",
+		},
+		cli.StringFlag{
+			Name:   "client-secret-file",
+			Usage:  "Read the client secret from the first line of this file",
+			EnvVar: "NETATMO_CLIENT_SECRET_FILE",
+		},
+		cli.BoolFlag{
+			Name:  "client-secret-stdin",
+			Usage: "Read the client secret from stdin",
+		},
+		cli.StringFlag{
+			Name:   "client-secret-command",
+			Usage:  "Run this command and read the client secret from its output",
+			EnvVar: "NETATMO_CLIENT_SECRET_COMMAND",
+		},
+		cli.StringFlag{
+			Name:   "token-file",
+			Usage:  "Where the token from login is stored, default ~/.config/atnetgo/token.json, token-<profile>.json for other profiles",
+			EnvVar: "NETATMO_TOKEN_FILE",
+		},
+		cli.StringFlag{
+			Name:   "scopes",
+			Usage:  "Comma separated OAuth scopes requested at login, e.g. read_station,read_homecoach. Default read_station",
+			EnvVar: "NETATMO_SCOPES",
+		},
+		cli.StringFlag{
+			Name:   "api-url",
+			Usage:  "Netatmo API url, default " + netatmo.DefaultBaseURL,
+			EnvVar: "NETATMO_API_URL",
+		},
+		cli.DurationFlag{
+			Name:   "timeout",
+			Usage:  "Give up on the API after this long, e.g. 30s. Default no limit",
+			EnvVar: "ATNETGO_TIMEOUT",
+		},
+		cli.IntFlag{
+			Name:   "retry-attempts",
+			Usage:  "Attempts per request on transient API failures, 1 disables retries. Default 3",
+			EnvVar: "ATNETGO_RETRY_ATTEMPTS",
+		},
+		cli.DurationFlag{
+			Name:   "retry-backoff",
+			Usage:  "Wait before the first retry, doubled for each following one. Default 1s",
+			EnvVar: "ATNETGO_RETRY_BACKOFF",
+		},
+		cli.DurationFlag{
+			Name:   "retry-max-backoff",
+			Usage:  "Longest wait between retries. Default 30s",
+			EnvVar: "ATNETGO_RETRY_MAX_BACKOFF",
+		},
+		cli.DurationFlag{
+			Name:   "retry-budget",
+			Usage:  "Total time a request may take including retries. Default 1m",
+			EnvVar: "ATNETGO_RETRY_BUDGET",
+		},
+		cli.StringFlag{
+			Name:   "rate-limit",
+			Usage:  "Client side rate limiting to stay within the Netatmo quotas: wait, fail or off. Default wait",
+			EnvVar: "ATNETGO_RATE_LIMIT",
+		},
+		cli.StringFlag{
+			Name:   "rate-limit-file",
+			Usage:  "Rate limit state shared by all atnetgo processes using the account, default next to the token file",
+			EnvVar: "ATNETGO_RATE_LIMIT_FILE",
+		},
+		cli.StringFlag{
+			Name:   "units",
+			Usage:  "Units of the values, metric or imperial",
+			EnvVar: "ATNETGO_UNITS",
+		},
+		cli.StringFlag{
+			Name:   "record",
+			Usage:  "Save every raw API response to this directory, with the tokens scrubbed",
+			EnvVar: "ATNETGO_RECORD",
+		},
+		cli.BoolFlag{
+			Name:  "scrub-location",
+			Usage: "Leave the location of the stations out of recorded responses",
+		},
+		cli.StringFlag{
+			Name:  "replay",
+			Usage: "Print a getstationsdata response saved with --record instead of asking the API",
+		},
+		cli.BoolFlag{
+			Name:   "trace",
+			Usage:  "Log every HTTP request and response in full, with tokens, passwords and secrets redacted",
+			EnvVar: "ATNETGO_TRACE",
+		},
+		cli.BoolFlag{
+			Name:   "allow-stale",
+			Usage:  "Print the values of the last successful read when the API fails, exit status 7",
+			EnvVar: "ATNETGO_ALLOW_STALE",
+		},
+		cli.StringFlag{
+			Name:   "cache-file",
+			Usage:  "Where the values of the last successful read are kept, default next to the token file",
+			EnvVar: "ATNETGO_CACHE_FILE",
+		},
+		cli.DurationFlag{
+			Name:   "max-cache-age",
+			Usage:  "Longest time cached values are printed without asking the API, while no new measures are due. Default 10m",
+			EnvVar: "ATNETGO_MAX_CACHE_AGE",
+		},
+		cli.BoolFlag{
+			Name:   "no-cache",
+			Usage:  "Always ask the API, even when no new measures are due",
+			EnvVar: "ATNETGO_NO_CACHE",
+		},
+		cli.StringFlag{
+			Name:   "log-level",
+			Value:  "info",
+			Usage:  "Least severe log entries written: debug, info, warning or error",
+			EnvVar: "ATNETGO_LOG_LEVEL",
+		},
+		cli.StringFlag{
+			Name:   "log-format",
+			Value:  logFormatText,
+			Usage:  "Format of the log entries, text or json",
+			EnvVar: "ATNETGO_LOG_FORMAT",
+		},
+		cli.StringFlag{
+			Name:   "log-file",
+			Usage:  "Append the log to this file instead of writing it to stderr",
+			EnvVar: "ATNETGO_LOG_FILE",
+		},
+		cli.StringFlag{
+			Name:   "output,o",
+			Usage:  "Write the output of the data commands to this file instead of stdout",
+			EnvVar: "ATNETGO_OUTPUT",
+		},
 	}
 
-	app.Run(os.Args)
-}
-
-func getDevices(ctx *cli.Context) *DeviceCollection {
-
-	config := netatmo.Config{
-		ClientID:     NetatmoAppID,
-		ClientSecret: NetatmoAppSecret,
-		Username:     ctx.GlobalString("user"),
-		Password:     ctx.GlobalString("password"),
-	}
-
-	n, err := netatmo.NewClient(config)
-	if err != nil {
-		log.WithFields(log.Fields{
-			"error": err.Error(),
-		}).Error("client error")
-		os.Exit(1)
-	}
-
-	dc, err := n.Read()
-	if err != nil {
-		log.WithFields(log.Fields{
-			"error": err.Error(),
-		}).Error("unable to fetch device collection")
-		os.Exit(1)
-	}
-
-	collection := filterDevices(ctx, dc)
-
-	return collection
-
-}
-
-func filterDevices(ctx *cli.Context, dc *netatmo.DeviceCollection) *DeviceCollection {
-	collection := &DeviceCollection{
-		NetatmoStations: dc.Stations(),
-	}
-
-	if sfilter := ctx.GlobalString("station"); sfilter != "" {
-		stations := []*netatmo.Device{}
-		for _, station := range collection.Stations() {
-			if matchesFilter(station, sfilter) {
-				stations = append(stations, station)
-			}
-		}
-		collection.NetatmoStations = stations
-	}
-
-	return collection
-}
-
-func matchesFilter(device *netatmo.Device, filter string) bool {
-	return strings.Index(device.StationName, filter) > -1
-}
-
-func listPrint(devices *DeviceCollection) {
-	for _, station := range devices.Stations() {
-		for _, module := range station.Modules() {
-			_, data := module.Data()
-			for dataType, value := range data {
-				fmt.Printf("%s: %s: %s: %s\n", station.StationName, module.ModuleName, dataType, valueString(value))
-			}
-		}
+	if err := app.Run(os.Args); err != nil {
+		log.WithFields(errorFields(err, nil)).Error("configuration error")
+		os.Exit(exitError)
 	}
 }
 
-func jsonPrint(devices *DeviceCollection) {
-
-	block := map[string]interface{}{}
-
-	for _, station := range devices.Stations() {
-		sblock := map[string]interface{}{}
-		for _, module := range station.Modules() {
-			mblock := map[string]string{}
-			_, data := module.Data()
-			for dataType, value := range data {
-				mblock[dataType] = valueString(value)
-			}
-			sblock[module.ModuleName] = mblock
-		}
-		block[station.StationName] = sblock
+// printDevices writes d to stdout in format, in the units of the profile
+func printDevices(format string, d *stations.DeviceCollection) {
+	printer, err := stations.NewPrinter(format, profile.Units)
+	if err == nil {
+		err = printer.Print(os.Stdout, d)
 	}
-
-	b, err := json.Marshal(block)
 	if err != nil {
-		panic(err)
-	}
-	fmt.Println(string(b))
-}
-
-func prettyPrint(devices *DeviceCollection) {
-	for _, station := range devices.Stations() {
-		fmt.Printf("Station: %s\n", station.StationName)
-		for _, module := range station.Modules() {
-			fmt.Printf("\t%s:\n", module.ModuleName)
-			_, data := module.Data()
-			for dataType, value := range data {
-				fmt.Printf("\t\t%s: %s\n", dataType, valueString(value))
-			}
-		}
-	}
-}
-
-func linePrint(devices *DeviceCollection) {
-
-	// some values should be represented as integers
-	typeSuffix := func(t string) string {
-		typemap := map[string]string{
-			"co2":      "i",
-			"humidity": "i",
-			"noise":    "i",
-		}
-		if s, ok := typemap[strings.ToLower(t)]; ok {
-			return s
-		}
-		return ""
-	}
-
-	tags := make([]string, 2)
-
-	for _, station := range devices.Stations() {
-		tags[0] = "station=" + strings.ToLower(station.StationName)
-
-		for _, module := range station.Modules() {
-			tags[1] = "module=" + strings.ToLower(module.ModuleName)
-
-			_, data := module.Data()
-			for dataType, value := range data {
-				tagstr := strings.Join(tags, ",")
-				tagstr = strings.Replace(tagstr, " ", "_", -1)
-				fmt.Printf("%s,%s value=%s%s\n", strings.ToLower(dataType), tagstr, valueString(value), typeSuffix(dataType))
-			}
-		}
-	}
-}
-
-func valueString(value interface{}) string {
-	switch value := value.(type) {
-	case float32, float64:
-		return fmt.Sprintf("%0.2f", value)
-	case string:
-		return value
-	case int, int32, int64:
-		return fmt.Sprintf("%d", value)
-	default:
-		return "-"
+		log.WithFields(errorFields(err, nil)).Error("unable to print")
+		os.Exit(exitError)
 	}
 }
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+	"github.com/dhogborg/atnetgo/netatmotest"
+)
+
+// mockServer serves the Netatmo API emulator from netatmotest until ctx is canceled,
+// with the demo station or the fixtures given with --fixtures
+func mockServer(ctx context.Context, c *cli.Context) {
+	fixtures := netatmotest.DemoFixtures()
+	if path := c.String("fixtures"); path != "" {
+		var err error
+		fixtures, err = netatmotest.LoadFixtures(expandHome(path))
+		if err != nil {
+			fatal(ctx, err, nil, "unable to load fixtures")
+		}
+	}
+	if delay := c.Duration("delay"); delay > 0 {
+		fixtures.Delay = delay
+	}
+	if lifetime := c.Duration("token-lifetime"); lifetime > 0 {
+		fixtures.TokenLifetime = lifetime
+	}
+
+	listener, err := net.Listen("tcp", c.String("listen"))
+	if err != nil {
+		fatal(ctx, err, nil, "unable to listen")
+	}
+
+	url := fmt.Sprintf("http://%s/", listener.Addr().String())
+	fmt.Printf("Netatmo API emulator listening on %s\n\n", url)
+	fmt.Printf("Log in with any user and password, e.g.\n\n")
+	fmt.Printf("  atnetgo --api-url %s --client-id demo --client-secret demo --user demo --password demo --token-file /tmp/atnetgo-demo.json pretty\n\n", url)
+
+	emulator := netatmotest.NewEmulator(fixtures)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log.WithFields(log.Fields{
+			"method": r.Method,
+			"path":   r.URL.Path,
+		}).Info("request")
+		emulator.ServeHTTP(w, r)
+	})
+
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	if err := http.Serve(listener, handler); err != nil && ctx.Err() == nil {
+		fatal(ctx, err, nil, "mock server failed")
+	}
+}
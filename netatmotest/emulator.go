@@ -0,0 +1,638 @@
+// Package netatmotest provides a local emulation of the Netatmo API for tests
+// and demos. It serves oauth2/token, oauth2/authorize, oauth2/revoke,
+// api/getstationsdata, api/getmeasure and api/getpublicdata from fixtures, and can inject
+// errors, expire tokens and slow down responses.
+//
+//	server := netatmotest.NewServer(netatmotest.DemoFixtures())
+//	defer server.Close()
+//
+//	config := netatmo.Config{BaseURL: server.URL}
+//	client := netatmo.NewClientWithToken(ctx, config, server.Token())
+package netatmotest
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// Emulated endpoints
+const (
+	TokenPath     = "oauth2/token"
+	AuthorizePath = "oauth2/authorize"
+	RevokePath    = "oauth2/revoke"
+	StationsPath  = "api/getstationsdata"
+	MeasurePath   = "api/getmeasure"
+	PublicPath    = "api/getpublicdata"
+)
+
+const (
+	// defaultLifetime is the access token lifetime of the real API
+	defaultLifetime = 3 * time.Hour
+	// maxMeasures is the most points getmeasure returns at once
+	maxMeasures = 1024
+)
+
+// Netatmo error codes returned by the emulator
+const (
+	codeInvalidAccessToken = 2
+	codeAccessTokenExpired = 3
+	codeNotFound           = 9
+	codeInsufficientScope  = 13
+	codeInvalidParams      = 21
+)
+
+// grant is what an access token, refresh token or authorization code allows
+type grant struct {
+	scopes []string
+	expiry time.Time
+}
+
+// Emulator is an http.Handler emulating the Netatmo API. It is safe for
+// concurrent use, the fixtures may be changed through its methods while serving.
+type Emulator struct {
+	mu       sync.Mutex
+	fixtures Fixtures
+	access   map[string]grant
+	refresh  map[string]grant
+	codes    map[string]grant
+	requests map[string]int
+}
+
+// NewEmulator returns an emulator serving f
+func NewEmulator(f *Fixtures) *Emulator {
+	fixtures := *f
+	fixtures.Failures = append([]Failure{}, f.Failures...)
+	if fixtures.TokenLifetime == 0 {
+		fixtures.TokenLifetime = defaultLifetime
+	}
+
+	return &Emulator{
+		fixtures: fixtures,
+		access:   map[string]grant{},
+		refresh:  map[string]grant{},
+		codes:    map[string]grant{},
+		requests: map[string]int{},
+	}
+}
+
+// Server is an Emulator listening on a local httptest.Server,
+// use its URL as the api url
+type Server struct {
+	*httptest.Server
+	*Emulator
+}
+
+// NewServer starts an emulator serving f
+func NewServer(f *Fixtures) *Server {
+	e := NewEmulator(f)
+	return &Server{Server: httptest.NewServer(e), Emulator: e}
+}
+
+// Token issues a valid token with scopes, read_station when none are given,
+// for clients that skip the login
+func (e *Emulator) Token(scopes ...string) *oauth2.Token {
+	if len(scopes) == 0 {
+		scopes = []string{"read_station"}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.issue(scopes)
+}
+
+// ExpireTokens makes every access token issued so far expire, requests
+// using them fail with "Access token expired" until the token is refreshed
+func (e *Emulator) ExpireTokens() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for token, g := range e.access {
+		g.expiry = time.Now().Add(-time.Second)
+		e.access[token] = g
+	}
+}
+
+// Fail adds a failure, see Failure
+func (e *Emulator) Fail(f Failure) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.fixtures.Failures = append(e.fixtures.Failures, f)
+}
+
+// SetDelay sets the delay added to every response
+func (e *Emulator) SetDelay(d time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.fixtures.Delay = d
+}
+
+// Requests returns how many requests were made to path, e.g. api/getstationsdata
+func (e *Emulator) Requests(path string) int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.requests[strings.TrimPrefix(path, "/")]
+}
+
+func (e *Emulator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/")
+
+	e.mu.Lock()
+	e.requests[path]++
+	delay := e.fixtures.Delay
+	failure, failed := e.failure(path)
+	e.mu.Unlock()
+
+	if delay > 0 {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+
+	if failed {
+		if failure.RetryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(failure.RetryAfter))
+		}
+		writeError(w, failure.Status, failure.Code, failure.Message)
+		return
+	}
+
+	switch path {
+	case TokenPath:
+		e.serveToken(w, r)
+	case AuthorizePath:
+		e.serveAuthorize(w, r)
+	case RevokePath:
+		e.serveRevoke(w, r)
+	case StationsPath:
+		e.serveStations(w, r)
+	case MeasurePath:
+		e.serveMeasure(w, r)
+	case PublicPath:
+		e.servePublic(w, r)
+	default:
+		writeError(w, http.StatusNotFound, 0, "Not found")
+	}
+}
+
+// failure returns the failure for a request to path, if any, and counts it
+func (e *Emulator) failure(path string) (Failure, bool) {
+	for i, f := range e.fixtures.Failures {
+		if f.Path != "" && strings.TrimPrefix(f.Path, "/") != path {
+			continue
+		}
+		if f.Times > 0 {
+			if f.Times == 1 {
+				e.fixtures.Failures = append(e.fixtures.Failures[:i], e.fixtures.Failures[i+1:]...)
+			} else {
+				e.fixtures.Failures[i].Times--
+			}
+		}
+		if f.Status == 0 {
+			f.Status = http.StatusInternalServerError
+		}
+		if f.Message == "" {
+			f.Message = http.StatusText(f.Status)
+		}
+		return f, true
+	}
+	return Failure{}, false
+}
+
+// issue creates an access and a refresh token, e.mu must be held
+func (e *Emulator) issue(scopes []string) *oauth2.Token {
+	expiry := time.Now().Add(e.fixtures.TokenLifetime)
+	token := &oauth2.Token{
+		AccessToken:  randomToken(),
+		TokenType:    "Bearer",
+		RefreshToken: randomToken(),
+		Expiry:       expiry,
+	}
+	e.access[token.AccessToken] = grant{scopes: scopes, expiry: expiry}
+	e.refresh[token.RefreshToken] = grant{scopes: scopes}
+
+	return token.WithExtra(map[string]interface{}{"scope": scopes})
+}
+
+// serveToken implements the password, authorization_code and refresh_token grants
+func (e *Emulator) serveToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeError(w, http.StatusMethodNotAllowed, 0, "Method not allowed")
+		return
+	}
+	r.ParseForm()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	id, secret, ok := r.BasicAuth()
+	if !ok {
+		id, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if (e.fixtures.ClientID != "" && id != e.fixtures.ClientID) ||
+		(e.fixtures.ClientSecret != "" && secret != e.fixtures.ClientSecret) {
+		writeOAuthError(w, "invalid_client")
+		return
+	}
+
+	var scopes []string
+	switch r.PostForm.Get("grant_type") {
+	case "password":
+		if (e.fixtures.Username != "" && r.PostForm.Get("username") != e.fixtures.Username) ||
+			(e.fixtures.Password != "" && r.PostForm.Get("password") != e.fixtures.Password) {
+			writeOAuthError(w, "invalid_grant")
+			return
+		}
+		scopes = strings.Fields(r.PostForm.Get("scope"))
+
+	case "authorization_code":
+		g, ok := e.codes[r.PostForm.Get("code")]
+		if !ok {
+			writeOAuthError(w, "invalid_grant")
+			return
+		}
+		delete(e.codes, r.PostForm.Get("code"))
+		scopes = g.scopes
+
+	case "refresh_token":
+		g, ok := e.refresh[r.PostForm.Get("refresh_token")]
+		if !ok {
+			writeOAuthError(w, "invalid_grant")
+			return
+		}
+		// refresh tokens are rotated, the old one can't be used again
+		delete(e.refresh, r.PostForm.Get("refresh_token"))
+		scopes = g.scopes
+
+	default:
+		writeOAuthError(w, "unsupported_grant_type")
+		return
+	}
+
+	if len(scopes) == 0 {
+		scopes = []string{"read_station"}
+	}
+	token := e.issue(scopes)
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token":  token.AccessToken,
+		"refresh_token": token.RefreshToken,
+		"expires_in":    int(e.fixtures.TokenLifetime / time.Second),
+		"expire_in":     int(e.fixtures.TokenLifetime / time.Second),
+		"scope":         scopes,
+	})
+}
+
+// serveAuthorize grants the requested scopes right away and redirects back with a code
+func (e *Emulator) serveAuthorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	redirect, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil || redirect.Host == "" {
+		writeError(w, http.StatusBadRequest, codeInvalidParams, "Invalid redirect_uri")
+		return
+	}
+
+	code := randomToken()
+	e.mu.Lock()
+	e.codes[code] = grant{scopes: strings.Fields(query.Get("scope"))}
+	e.mu.Unlock()
+
+	values := redirect.Query()
+	values.Set("code", code)
+	values.Set("state", query.Get("state"))
+	redirect.RawQuery = values.Encode()
+
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+// serveRevoke revokes an access or refresh token as described in RFC 7009
+func (e *Emulator) serveRevoke(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	token := r.PostForm.Get("token")
+
+	e.mu.Lock()
+	delete(e.access, token)
+	delete(e.refresh, token)
+	e.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{})
+}
+
+// authorize checks the access token of r, from the Authorization header or
+// the access_token parameter, and writes the error when it is not valid for scope
+func (e *Emulator) authorize(w http.ResponseWriter, r *http.Request, scope string) bool {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		token = r.FormValue("access_token")
+	}
+
+	e.mu.Lock()
+	g, ok := e.access[token]
+	e.mu.Unlock()
+
+	switch {
+	case !ok:
+		writeError(w, http.StatusForbidden, codeInvalidAccessToken, "Invalid access token")
+	case g.expiry.Before(time.Now()):
+		writeError(w, http.StatusForbidden, codeAccessTokenExpired, "Access token expired")
+	case !contains(g.scopes, scope):
+		writeError(w, http.StatusForbidden, codeInsufficientScope, "Application does not have the good scope rights")
+	default:
+		return true
+	}
+	return false
+}
+
+// serveStations serves the devices, or the one given with device_id
+func (e *Emulator) serveStations(w http.ResponseWriter, r *http.Request) {
+	if !e.authorize(w, r, "read_station") {
+		return
+	}
+
+	e.mu.Lock()
+	devices := e.fixtures.Devices
+	user := e.fixtures.User
+	e.mu.Unlock()
+
+	if id := r.FormValue("device_id"); id != "" {
+		all := devices
+		devices = nil
+		for _, device := range all {
+			d := struct {
+				ID string `json:"_id"`
+			}{}
+			if json.Unmarshal(device, &d) == nil && d.ID == id {
+				devices = append(devices, device)
+			}
+		}
+		if len(devices) == 0 {
+			writeError(w, http.StatusBadRequest, codeNotFound, "Device not found")
+			return
+		}
+	}
+	if devices == nil {
+		devices = []json.RawMessage{}
+	}
+	if user == nil {
+		user = json.RawMessage(`{}`)
+	}
+
+	writeBody(w, map[string]interface{}{
+		"devices": devices,
+		"user":    user,
+	})
+}
+
+// serveMeasure serves the measures of device_id or module_id, in the optimized
+// format unless optimize=false. The series are served as they are for every scale.
+func (e *Emulator) serveMeasure(w http.ResponseWriter, r *http.Request) {
+	if !e.authorize(w, r, "read_station") {
+		return
+	}
+
+	for _, param := range []string{"device_id", "scale", "type"} {
+		if r.FormValue(param) == "" {
+			writeError(w, http.StatusBadRequest, codeInvalidParams, "Missing "+param)
+			return
+		}
+	}
+
+	id := r.FormValue("module_id")
+	if id == "" {
+		id = r.FormValue("device_id")
+	}
+
+	e.mu.Lock()
+	series, ok := e.fixtures.Measures[id]
+	e.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusBadRequest, codeNotFound, "Device not found")
+		return
+	}
+
+	begin, _ := strconv.ParseInt(r.FormValue("date_begin"), 10, 64)
+	end, _ := strconv.ParseInt(r.FormValue("date_end"), 10, 64)
+	if end == 0 {
+		end = time.Now().Unix()
+	}
+	limit, _ := strconv.Atoi(r.FormValue("limit"))
+	if limit <= 0 || limit > maxMeasures {
+		limit = maxMeasures
+	}
+	types := strings.Split(r.FormValue("type"), ",")
+
+	points := []Measure{}
+	for _, m := range series {
+		if m.Time >= begin && m.Time <= end {
+			points = append(points, m)
+		}
+	}
+	sort.Sort(byTime(points))
+	if len(points) > limit {
+		// like Netatmo, the newest points before date_end when date_begin is missing
+		if r.FormValue("date_begin") == "" {
+			points = points[len(points)-limit:]
+		} else {
+			points = points[:limit]
+		}
+	}
+
+	values := func(m Measure) []interface{} {
+		v := make([]interface{}, len(types))
+		for i, t := range types {
+			for name, value := range m.Values {
+				if strings.EqualFold(name, t) {
+					v[i] = value
+				}
+			}
+		}
+		return v
+	}
+
+	if r.FormValue("optimize") == "false" {
+		body := map[string][]interface{}{}
+		for _, m := range points {
+			body[strconv.FormatInt(m.Time, 10)] = values(m)
+		}
+		writeBody(w, body)
+		return
+	}
+
+	// consecutive points with the same interval share a block
+	type block struct {
+		BegTime  int64           `json:"beg_time"`
+		StepTime int64           `json:"step_time,omitempty"`
+		Value    [][]interface{} `json:"value"`
+	}
+	blocks := []*block{}
+	for i, m := range points {
+		var last *block
+		if len(blocks) > 0 {
+			last = blocks[len(blocks)-1]
+		}
+		step := int64(0)
+		if i > 0 {
+			step = m.Time - points[i-1].Time
+		}
+
+		switch {
+		case last != nil && len(last.Value) == 1:
+			last.StepTime = step
+			last.Value = append(last.Value, values(m))
+		case last != nil && last.StepTime == step:
+			last.Value = append(last.Value, values(m))
+		default:
+			blocks = append(blocks, &block{BegTime: m.Time, Value: [][]interface{}{values(m)}})
+		}
+	}
+	writeBody(w, blocks)
+}
+
+type byTime []Measure
+
+func (m byTime) Len() int           { return len(m) }
+func (m byTime) Less(i, j int) bool { return m[i].Time < m[j].Time }
+func (m byTime) Swap(i, j int)      { m[i], m[j] = m[j], m[i] }
+
+// writeBody writes a successful API response holding body
+func writeBody(w http.ResponseWriter, body interface{}) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"body":        body,
+		"status":      "ok",
+		"time_exec":   0.01,
+		"time_server": time.Now().Unix(),
+	})
+}
+
+// writeError writes an API error payload
+func writeError(w http.ResponseWriter, status, code int, message string) {
+	writeJSON(w, status, map[string]interface{}{
+		"error": map[string]interface{}{
+			"code":    code,
+			"message": message,
+		},
+	})
+}
+
+// writeOAuthError writes an error of the token endpoint
+func writeOAuthError(w http.ResponseWriter, err string) {
+	writeJSON(w, http.StatusBadRequest, map[string]string{"error": err})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func randomToken() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// servePublic serves the public stations located in the area of lat_ne, lon_ne,
+// lat_sw and lon_sw that have every data of the comma separated required_data.
+// filter is ignored.
+func (e *Emulator) servePublic(w http.ResponseWriter, r *http.Request) {
+	if !e.authorize(w, r, "read_station") {
+		return
+	}
+	r.ParseForm()
+
+	bounds := map[string]float64{}
+	for _, param := range []string{"lat_ne", "lon_ne", "lat_sw", "lon_sw"} {
+		v, err := strconv.ParseFloat(r.Form.Get(param), 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidParams, "Missing or invalid "+param)
+			return
+		}
+		bounds[param] = v
+	}
+	if len(r.Form["required_data"]) > 1 {
+		writeError(w, http.StatusBadRequest, codeInvalidParams, "Invalid required_data")
+		return
+	}
+	required := []string{}
+	if data := r.Form.Get("required_data"); data != "" {
+		required = strings.Split(data, ",")
+	}
+
+	e.mu.Lock()
+	public := e.fixtures.Public
+	e.mu.Unlock()
+
+	stations := []json.RawMessage{}
+	for _, raw := range public {
+		s := struct {
+			Place struct {
+				Location []float64 `json:"location"`
+			} `json:"place"`
+			Measures map[string]map[string]json.RawMessage `json:"measures"`
+		}{}
+		if json.Unmarshal(raw, &s) != nil || len(s.Place.Location) != 2 {
+			continue
+		}
+
+		lon, lat := s.Place.Location[0], s.Place.Location[1]
+		if lat > bounds["lat_ne"] || lat < bounds["lat_sw"] || lon > bounds["lon_ne"] || lon < bounds["lon_sw"] {
+			continue
+		}
+		if hasPublicData(s.Measures, required) {
+			stations = append(stations, raw)
+		}
+	}
+
+	writeBody(w, stations)
+}
+
+// hasPublicData tells if the measures of a public station have every data in required
+func hasPublicData(measures map[string]map[string]json.RawMessage, required []string) bool {
+	for _, data := range required {
+		found := false
+		for _, m := range measures {
+			types := []string{}
+			json.Unmarshal(m["type"], &types)
+
+			switch data {
+			case "rain":
+				_, found = m["rain_live"]
+			case "wind":
+				_, found = m["wind_strength"]
+			default:
+				found = contains(types, data)
+			}
+			if found {
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
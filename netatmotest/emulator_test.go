@@ -0,0 +1,497 @@
+package netatmotest
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	netatmo "github.com/dhogborg/netatmo-api-go"
+	"golang.org/x/oauth2"
+)
+
+func TestReadDemo(t *testing.T) {
+	s := NewServer(DemoFixtures())
+	defer s.Close()
+
+	c := netatmo.NewClientWithToken(context.Background(), netatmo.Config{BaseURL: s.URL}, s.Token())
+	dc, err := c.Read(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stations := dc.Stations()
+	if len(stations) != 1 || stations[0].StationName != "Demo" || len(stations[0].Modules()) != 4 {
+		t.Fatalf("unexpected stations %+v", stations)
+	}
+	if lat, lon, ok := stations[0].Location(); !ok || lat != 59.3293 || lon != 18.0686 {
+		t.Errorf("got location %v, %v, %v, want 59.3293, 18.0686", lat, lon, ok)
+	}
+	if stations[0].DateSetup == 0 || stations[0].Modules()[1].DateSetup != stations[0].DateSetup {
+		t.Errorf("got setup dates %d and %d, want the same for station and modules", stations[0].DateSetup, stations[0].Modules()[1].DateSetup)
+	}
+	if dc.User().Mail != "demo@example.com" {
+		t.Errorf("got user %q, want demo@example.com", dc.User().Mail)
+	}
+	if _, data := stations[0].Data(); data["Temperature"] == float32(0) {
+		t.Errorf("station without temperature: %v", data)
+	}
+	if n := s.Requests(StationsPath); n != 1 {
+		t.Errorf("%d requests counted, want 1", n)
+	}
+}
+
+func TestPasswordGrant(t *testing.T) {
+	f := DemoFixtures()
+	f.ClientID, f.ClientSecret = "id", "secret"
+	f.Username, f.Password = "me@example.com", "hunter2"
+	s := NewServer(f)
+	defer s.Close()
+
+	config := netatmo.Config{
+		ClientID:     "id",
+		ClientSecret: "secret",
+		Username:     "me@example.com",
+		Password:     "hunter2",
+		BaseURL:      s.URL,
+	}
+	c, err := netatmo.NewClient(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Read(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	config.Password = "wrong"
+	if _, err := netatmo.PasswordToken(context.Background(), config); err == nil {
+		t.Fatal("wrong password accepted")
+	} else if _, ok := err.(*netatmo.AuthError); !ok {
+		t.Errorf("got %T %v, want AuthError", err, err)
+	}
+}
+
+func TestExpiredTokens(t *testing.T) {
+	s := NewServer(DemoFixtures())
+	defer s.Close()
+	config := netatmo.Config{BaseURL: s.URL}
+
+	// expired on the server only, the client does not know it has to refresh
+	token := s.Token()
+	s.ExpireTokens()
+	c := netatmo.NewClientWithToken(context.Background(), config, token)
+	_, err := c.Read(context.Background())
+	if aerr, ok := err.(*netatmo.AuthError); !ok || aerr.Code != netatmo.ErrCodeAccessTokenExpired {
+		t.Fatalf("got %T %v, want access token expired", err, err)
+	}
+
+	// known to be expired, it is refreshed first
+	token.Expiry = time.Now().Add(-time.Minute)
+	c = netatmo.NewClientWithToken(context.Background(), config, token)
+	if _, err := c.Read(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := s.Requests(TokenPath); n != 1 {
+		t.Errorf("%d token requests, want 1", n)
+	}
+
+	// the refresh token was rotated
+	c = netatmo.NewClientWithToken(context.Background(), config, token)
+	if _, err := c.Read(context.Background()); err == nil {
+		t.Error("refresh token used twice")
+	}
+}
+
+func TestMissingScope(t *testing.T) {
+	s := NewServer(DemoFixtures())
+	defer s.Close()
+
+	// the client does not know the scopes of this token, so the API rejects it
+	token := s.Token("read_homecoach")
+	token = &oauth2.Token{AccessToken: token.AccessToken, Expiry: token.Expiry}
+
+	c := netatmo.NewClientWithToken(context.Background(), netatmo.Config{BaseURL: s.URL}, token)
+	_, err := c.Read(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "missing scope read_station") {
+		t.Fatalf("got %v, want missing scope read_station", err)
+	}
+}
+
+func TestFailures(t *testing.T) {
+	f := DemoFixtures()
+	f.Failures = []Failure{{Path: StationsPath, Status: 503, Code: 1, Message: "Unavailable", Times: 2}}
+	s := NewServer(f)
+	defer s.Close()
+
+	config := netatmo.Config{
+		BaseURL: s.URL,
+		Retry:   netatmo.RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond},
+	}
+	c := netatmo.NewClientWithToken(context.Background(), config, s.Token())
+	if _, err := c.Read(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := s.Requests(StationsPath); n != 3 {
+		t.Errorf("%d requests, want 3", n)
+	}
+
+	s.Fail(Failure{Path: StationsPath, Status: 429, Code: 26, Message: "User usage reached", RetryAfter: 60, Times: 1})
+	c = netatmo.NewClientWithToken(context.Background(), netatmo.Config{BaseURL: s.URL}, s.Token())
+	_, err := c.Read(context.Background())
+	if rerr, ok := err.(*netatmo.RateLimitError); !ok || rerr.RetryAfter != time.Minute {
+		t.Fatalf("got %T %v, want rate limited for a minute", err, err)
+	}
+}
+
+func TestTokenEndpointFailures(t *testing.T) {
+	s := NewServer(DemoFixtures())
+	defer s.Close()
+	config := netatmo.Config{
+		BaseURL: s.URL,
+		Retry:   netatmo.RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond},
+	}
+	expired := func() *oauth2.Token {
+		token := s.Token()
+		token.Expiry = time.Now().Add(-time.Minute)
+		return token
+	}
+
+	// an outage or rate limit during the refresh is retried, not a failed login
+	s.Fail(Failure{Path: TokenPath, Status: 503, Times: 1})
+	s.Fail(Failure{Path: TokenPath, Status: 429, Times: 1})
+	c := netatmo.NewClientWithToken(context.Background(), config, expired())
+	if _, err := c.Read(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := s.Requests(TokenPath); n != 3 {
+		t.Errorf("%d token requests, want 3", n)
+	}
+
+	s.Fail(Failure{Path: TokenPath, Status: 502})
+	c = netatmo.NewClientWithToken(context.Background(), config, expired())
+	if _, err := c.Read(context.Background()); err == nil {
+		t.Fatal("read without a token")
+	} else if _, ok := err.(*netatmo.ServerError); !ok {
+		t.Errorf("got %T %v, want ServerError", err, err)
+	}
+	if _, err := netatmo.PasswordToken(context.Background(), config); err == nil {
+		t.Fatal("password grant succeeded")
+	} else if _, ok := err.(*netatmo.ServerError); !ok {
+		t.Errorf("got %T %v, want ServerError", err, err)
+	}
+}
+
+func TestDelay(t *testing.T) {
+	f := DemoFixtures()
+	f.Delay = time.Second
+	s := NewServer(f)
+	defer s.Close()
+
+	c := netatmo.NewClientWithToken(context.Background(), netatmo.Config{BaseURL: s.URL}, s.Token())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	started := time.Now()
+	if _, err := c.Read(ctx); err == nil {
+		t.Fatal("slow response did not time out")
+	}
+	if time.Since(started) > 500*time.Millisecond {
+		t.Error("request was not canceled in time")
+	}
+
+	s.SetDelay(0)
+	if _, err := c.Read(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// getMeasure requests getmeasure with the given parameters and decodes the body into body
+func getMeasure(t *testing.T, s *Server, params url.Values, body interface{}) int {
+	params.Set("access_token", s.Token().AccessToken)
+	resp, err := http.Get(s.URL + "/" + MeasurePath + "?" + params.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	payload := struct {
+		Body interface{} `json:"body"`
+	}{body}
+	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode
+}
+
+func TestMeasure(t *testing.T) {
+	f := &Fixtures{Measures: map[string][]Measure{
+		"02:00:00:00:00:01": {
+			{Time: 1000, Values: map[string]float64{"Temperature": 5, "Humidity": 80}},
+			{Time: 1300, Values: map[string]float64{"Temperature": 6, "Humidity": 81}},
+			{Time: 1600, Values: map[string]float64{"Temperature": 7}},
+			{Time: 2500, Values: map[string]float64{"Temperature": 8, "Humidity": 83}},
+		},
+	}}
+	s := NewServer(f)
+	defer s.Close()
+
+	params := url.Values{
+		"device_id": {"70:ee:50:00:00:01"},
+		"module_id": {"02:00:00:00:00:01"},
+		"scale":     {"max"},
+		"type":      {"temperature,Humidity"},
+		"optimize":  {"false"},
+		"date_end":  {"2000"},
+	}
+	plain := map[string][]*float64{}
+	if status := getMeasure(t, s, params, &plain); status != 200 {
+		t.Fatalf("status %d", status)
+	}
+	if len(plain) != 3 || *plain["1300"][0] != 6 || *plain["1300"][1] != 81 || plain["1600"][1] != nil {
+		t.Errorf("unexpected measures %v", plain)
+	}
+
+	params.Set("optimize", "true")
+	params.Del("date_end")
+	params.Set("date_begin", "1300")
+	blocks := []struct {
+		BegTime  int64        `json:"beg_time"`
+		StepTime int64        `json:"step_time"`
+		Value    [][]*float64 `json:"value"`
+	}{}
+	getMeasure(t, s, params, &blocks)
+	if len(blocks) != 2 || blocks[0].BegTime != 1300 || blocks[0].StepTime != 300 || len(blocks[0].Value) != 2 ||
+		blocks[1].BegTime != 2500 || len(blocks[1].Value) != 1 {
+		t.Errorf("unexpected blocks %+v", blocks)
+	}
+
+	params.Set("limit", "1")
+	params.Set("optimize", "false")
+	limited := map[string]interface{}{}
+	getMeasure(t, s, params, &limited)
+	if len(limited) != 1 || limited["1300"] == nil {
+		t.Errorf("got %v, want the measure at 1300", limited)
+	}
+
+	// without a begin the newest measures are returned
+	params.Del("date_begin")
+	newest := map[string]interface{}{}
+	getMeasure(t, s, params, &newest)
+	if len(newest) != 1 || newest["2500"] == nil {
+		t.Errorf("got %v, want the measure at 2500", newest)
+	}
+
+	params.Set("module_id", "unknown")
+	if status := getMeasure(t, s, params, &limited); status != http.StatusBadRequest {
+		t.Errorf("unknown module gave status %d", status)
+	}
+}
+
+func TestReadMeasuresPaging(t *testing.T) {
+	series := []Measure{}
+	for i := int64(0); i < 2500; i++ {
+		series = append(series, Measure{Time: 1000 + i*300, Values: map[string]float64{"Temperature": float64(i)}})
+	}
+	s := NewServer(&Fixtures{Measures: map[string][]Measure{DemoOutdoorID: series}})
+	defer s.Close()
+
+	c := netatmo.NewClientWithToken(context.Background(), netatmo.Config{BaseURL: s.URL}, s.Token())
+	q := netatmo.MeasureQuery{
+		DeviceID: DemoStationID,
+		ModuleID: DemoOutdoorID,
+		Scale:    netatmo.ScaleMax,
+		Types:    []string{"Temperature", "Humidity"},
+		Begin:    time.Unix(1000, 0),
+	}
+	measures, err := c.ReadMeasures(context.Background(), q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(measures) != 2500 || s.Requests(MeasurePath) != 3 {
+		t.Fatalf("got %d measures in %d requests, want 2500 in 3", len(measures), s.Requests(MeasurePath))
+	}
+	for i, m := range measures {
+		if m.Time.Unix() != 1000+int64(i)*300 || m.Values["Temperature"] != float64(i) {
+			t.Fatalf("measure %d is %v", i, m)
+		}
+		if _, ok := m.Values["Humidity"]; ok {
+			t.Fatalf("measure %d has a humidity", i)
+		}
+	}
+
+	q.Limit = 1500
+	q.End = time.Unix(1000+2000*300, 0)
+	if measures, err = c.ReadMeasures(context.Background(), q); err != nil || len(measures) != 1500 {
+		t.Errorf("got %d measures, %v, want 1500", len(measures), err)
+	}
+
+	q.Limit = 0
+	if measures, err = c.ReadMeasures(context.Background(), q); err != nil || len(measures) != 2001 {
+		t.Errorf("got %d measures, %v, want 2001", len(measures), err)
+	}
+
+	// without a begin one request reads the newest measures before the end
+	q.Begin = time.Time{}
+	requests := s.Requests(MeasurePath)
+	measures, err = c.ReadMeasures(context.Background(), q)
+	if err != nil || len(measures) != netatmo.MaxMeasures || s.Requests(MeasurePath) != requests+1 {
+		t.Fatalf("got %d measures in %d requests, %v, want %d in 1", len(measures), s.Requests(MeasurePath)-requests, err, netatmo.MaxMeasures)
+	}
+	if first, last := measures[0].Time.Unix(), measures[len(measures)-1].Time.Unix(); first != 1000+977*300 || last != q.End.Unix() {
+		t.Errorf("got measures from %d to %d, want %d to %d", first, last, 1000+977*300, q.End.Unix())
+	}
+}
+
+func TestReadPublic(t *testing.T) {
+	s := NewServer(DemoFixtures())
+	defer s.Close()
+
+	c := netatmo.NewClientWithToken(context.Background(), netatmo.Config{BaseURL: s.URL}, s.Token())
+	q := netatmo.PublicQuery{Area: netatmo.Area{LatNE: 59.4, LonNE: 18.2, LatSW: 59.2, LonSW: 17.9}}
+	stations, err := c.ReadPublic(context.Background(), q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stations) != 8 {
+		t.Fatalf("got %d stations, want 8", len(stations))
+	}
+
+	byID := map[string]*netatmo.PublicStation{}
+	for _, station := range stations {
+		byID[station.ID] = station
+	}
+	first := byID["70:ee:50:00:01:00"]
+	if first == nil || first.City != "Stockholm" || first.Latitude < 59.3 || first.Longitude < 18 || first.Time.IsZero() {
+		t.Fatalf("unexpected first station %+v", first)
+	}
+	want := map[string]float64{
+		"Temperature":  7,
+		"Humidity":     70,
+		"Pressure":     1010,
+		"Rain":         0,
+		"Rain1Hour":    0,
+		"Rain1Day":     0,
+		"WindStrength": 10,
+		"WindAngle":    0,
+		"GustStrength": 18,
+		"GustAngle":    10,
+	}
+	for dataType, value := range want {
+		if got, ok := first.Values[dataType]; !ok || got != value {
+			t.Errorf("got %s %v, want %v", dataType, got, value)
+		}
+	}
+
+	q.RequiredData = []string{netatmo.PublicRain}
+	if stations, err = c.ReadPublic(context.Background(), q); err != nil || len(stations) != 4 {
+		t.Errorf("got %d stations with rain, %v, want 4", len(stations), err)
+	}
+	q.RequiredData = []string{netatmo.PublicRain, netatmo.PublicWind}
+	if stations, err = c.ReadPublic(context.Background(), q); err != nil || len(stations) != 2 {
+		t.Errorf("got %d stations with rain and wind, %v, want 2", len(stations), err)
+	}
+
+	// east of the demo station only
+	q = netatmo.PublicQuery{Area: netatmo.Area{LatNE: 59.4, LonNE: 18.2, LatSW: 59.2, LonSW: 18.08}}
+	if stations, err = c.ReadPublic(context.Background(), q); err != nil || len(stations) != 3 {
+		t.Errorf("got %d stations in the east, %v, want 3", len(stations), err)
+	}
+}
+
+func TestAuthorizationCode(t *testing.T) {
+	s := NewServer(DemoFixtures())
+	defer s.Close()
+
+	oauth := netatmo.OAuthConfig(netatmo.Config{
+		BaseURL:     s.URL,
+		RedirectURL: "http://localhost:8726/callback",
+		Scopes:      []string{netatmo.ScopeReadStation, netatmo.ScopeReadHomeCoach},
+	})
+
+	noRedirect := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := noRedirect.Get(oauth.AuthCodeURL("xyz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	redirect, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || redirect.Query().Get("state") != "xyz" || redirect.Query().Get("code") == "" {
+		t.Fatalf("unexpected redirect %q", resp.Header.Get("Location"))
+	}
+
+	token, err := oauth.Exchange(context.Background(), redirect.Query().Get("code"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scopes := netatmo.TokenScopes(token); strings.Join(scopes, " ") != "read_station read_homecoach" {
+		t.Errorf("granted %v", scopes)
+	}
+	if _, err := oauth.Exchange(context.Background(), redirect.Query().Get("code")); err == nil {
+		t.Error("code exchanged twice")
+	}
+}
+
+func TestLoadFixtures(t *testing.T) {
+	dir, err := ioutil.TempDir("", "netatmotest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "fixtures.json")
+	content := `{
+		"body": {"devices": [{"_id": "70:ee:50:00:00:02", "station_name": "Cabin", "type": "NAMain",
+			"dashboard_data": {"Temperature": 12.5, "time_utc": 1700000000}}], "user": {"mail": "cabin@example.com"}},
+		"measures": {"70:ee:50:00:00:02": [{"time": 1700000000, "values": {"Temperature": 12.5}}]},
+		"failures": [{"path": "api/getstationsdata", "status": 500, "times": 1}],
+		"delay": "10ms",
+		"token_lifetime": "1m"
+	}`
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := LoadFixtures(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Delay != 10*time.Millisecond || f.TokenLifetime != time.Minute || len(f.Failures) != 1 {
+		t.Errorf("unexpected fixtures %+v", f)
+	}
+
+	s := NewServer(f)
+	defer s.Close()
+	token := s.Token()
+	if left := token.Expiry.Sub(time.Now()); left > time.Minute {
+		t.Errorf("token expires in %s, want a minute", left)
+	}
+
+	c := netatmo.NewClientWithToken(context.Background(), netatmo.Config{BaseURL: s.URL}, token)
+	if _, err := c.Read(context.Background()); err == nil {
+		t.Fatal("failure not served")
+	}
+	dc, err := c.Read(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := dc.Stations()[0].StationName; name != "Cabin" {
+		t.Errorf("got station %q", name)
+	}
+
+	if err := ioutil.WriteFile(path, []byte(`{"delay": "soon"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadFixtures(path); err == nil || !strings.Contains(err.Error(), strconv.Quote("soon")) {
+		t.Errorf("got %v, want an error about the delay", err)
+	}
+}
@@ -0,0 +1,297 @@
+package netatmotest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"strconv"
+	"time"
+)
+
+// Fixtures are the data served by the emulator
+// Devices : Stations as in the devices list of the getstationsdata body, served as they are
+// User : User object of the getstationsdata body
+// Measures : getmeasure series by module id, or by device id for the station itself
+// Public : Community stations as in the getpublicdata body, served as they are
+// Failures : Errors returned instead of the data, see Failure
+// Delay : Added to every response, to emulate a slow API
+// TokenLifetime : Lifetime of the issued access tokens, default 3 hours
+// ClientID, ClientSecret : App credentials the token endpoint accepts, any when empty
+// Username, Password : Login the password grant accepts, any when empty
+type Fixtures struct {
+	Devices  []json.RawMessage
+	User     json.RawMessage
+	Measures map[string][]Measure
+	Public   []json.RawMessage
+	Failures []Failure
+
+	Delay         time.Duration
+	TokenLifetime time.Duration
+
+	ClientID     string
+	ClientSecret string
+	Username     string
+	Password     string
+}
+
+// Measure is one point of a getmeasure series
+// Time : Unix timestamp of the measure
+// Values : Value per measure type, e.g. "Temperature": 21.5
+type Measure struct {
+	Time   int64              `json:"time"`
+	Values map[string]float64 `json:"values"`
+}
+
+// Failure makes the emulator answer requests to Path with an error
+// Path : Endpoint to fail, e.g. api/getstationsdata or oauth2/token, all when empty
+// Status : HTTP status, default 500
+// Code, Message : Netatmo error code and message of the error payload
+// RetryAfter : Seconds in the Retry-After header, left out when 0
+// Times : How many requests fail before the data is served again, 0 for all of them
+type Failure struct {
+	Path       string `json:"path"`
+	Status     int    `json:"status"`
+	Code       int    `json:"code"`
+	Message    string `json:"message"`
+	RetryAfter int    `json:"retry_after"`
+	Times      int    `json:"times"`
+}
+
+// fixtureFile is the layout of a fixtures file, see LoadFixtures
+type fixtureFile struct {
+	Body struct {
+		Devices []json.RawMessage `json:"devices"`
+		User    json.RawMessage   `json:"user"`
+	} `json:"body"`
+	Measures      map[string][]Measure `json:"measures"`
+	Public        []json.RawMessage    `json:"public"`
+	Failures      []Failure            `json:"failures"`
+	Delay         string               `json:"delay"`
+	TokenLifetime string               `json:"token_lifetime"`
+	ClientID      string               `json:"client_id"`
+	ClientSecret  string               `json:"client_secret"`
+	Username      string               `json:"username"`
+	Password      string               `json:"password"`
+}
+
+// LoadFixtures reads fixtures from a JSON file. The body is a getstationsdata
+// response as returned by the API, so a saved response can be used as it is.
+//
+//	{
+//	  "body": {"devices": [...], "user": {"mail": "me@example.com"}},
+//	  "measures": {"02:00:00:00:00:01": [{"time": 1700000000, "values": {"Temperature": 5.5}}]},
+//	  "public": [{"_id": "70:ee:50:00:00:10", "place": {"location": [18.07, 59.33]}, "measures": {...}}],
+//	  "failures": [{"path": "api/getstationsdata", "status": 503, "code": 1, "message": "Unavailable", "times": 2}],
+//	  "delay": "500ms",
+//	  "token_lifetime": "1h"
+//	}
+func LoadFixtures(path string) (*Fixtures, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	file := fixtureFile{}
+	if err := json.Unmarshal(b, &file); err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %s", path, err.Error())
+	}
+
+	f := &Fixtures{
+		Devices:      file.Body.Devices,
+		User:         file.Body.User,
+		Measures:     file.Measures,
+		Public:       file.Public,
+		Failures:     file.Failures,
+		ClientID:     file.ClientID,
+		ClientSecret: file.ClientSecret,
+		Username:     file.Username,
+		Password:     file.Password,
+	}
+
+	durations := []struct {
+		value string
+		d     *time.Duration
+	}{
+		{file.Delay, &f.Delay},
+		{file.TokenLifetime, &f.TokenLifetime},
+	}
+	for _, d := range durations {
+		if d.value == "" {
+			continue
+		}
+		if *d.d, err = time.ParseDuration(d.value); err != nil {
+			return nil, fmt.Errorf("unable to parse %s: %s", path, err.Error())
+		}
+	}
+
+	return f, nil
+}
+
+// Demo station and module ids of DemoFixtures
+const (
+	DemoStationID = "70:ee:50:00:00:01"
+	DemoOutdoorID = "02:00:00:00:00:01"
+	DemoRainID    = "05:00:00:00:00:01"
+	DemoWindID    = "06:00:00:00:00:01"
+)
+
+// DemoFixtures returns a station in Stockholm with an outdoor, a rain and
+// a wind module, a week of half hourly measures up to now and a few public
+// stations around it, see DemoPublic
+func DemoFixtures() *Fixtures {
+	now := time.Now().Unix()
+	now -= now % 1800
+
+	round := func(v float64) float64 { return math.Floor(v*10+0.5) / 10 }
+
+	// the demo station was set up a week ago, with all its modules
+	setup := now - 7*24*3600
+
+	measures := map[string][]Measure{}
+	for t := setup; t <= now; t += 1800 {
+		// a daily cycle, coldest at 4 in the morning
+		day := -math.Cos(2 * math.Pi * float64(t%86400-4*3600) / 86400)
+
+		measures[DemoStationID] = append(measures[DemoStationID], Measure{Time: t, Values: map[string]float64{
+			"Temperature": round(21 + day),
+			"Humidity":    math.Floor(45 - 5*day),
+			"CO2":         math.Floor(600 + 200*day),
+			"Noise":       math.Floor(38 + 4*day),
+			"Pressure":    round(1013 + 3*math.Sin(2*math.Pi*float64(t)/(4*86400))),
+		}})
+		measures[DemoOutdoorID] = append(measures[DemoOutdoorID], Measure{Time: t, Values: map[string]float64{
+			"Temperature": round(8 + 6*day),
+			"Humidity":    math.Floor(75 - 15*day),
+		}})
+		measures[DemoRainID] = append(measures[DemoRainID], Measure{Time: t, Values: map[string]float64{
+			"Rain": round(math.Max(0, 0.6*math.Sin(2*math.Pi*float64(t)/(3*86400)))),
+		}})
+		measures[DemoWindID] = append(measures[DemoWindID], Measure{Time: t, Values: map[string]float64{
+			"WindStrength": math.Floor(12 + 6*day),
+			"WindAngle":    float64((t / 1800 * 7) % 360),
+			"GustStrength": math.Floor(20 + 9*day),
+			"GustAngle":    float64((t/1800*7 + 15) % 360),
+		}})
+	}
+
+	last := func(id string) map[string]float64 {
+		series := measures[id]
+		return series[len(series)-1].Values
+	}
+	dashboard := func(id string, extra map[string]float64) map[string]float64 {
+		data := map[string]float64{"time_utc": float64(now)}
+		for k, v := range last(id) {
+			data[k] = v
+		}
+		for k, v := range extra {
+			data[k] = v
+		}
+		return data
+	}
+
+	station := map[string]interface{}{
+		"_id":            DemoStationID,
+		"station_name":   "Demo",
+		"module_name":    "Indoor",
+		"type":           "NAMain",
+		"date_setup":     setup,
+		"data_type":      []string{"Temperature", "CO2", "Humidity", "Noise", "Pressure"},
+		"dashboard_data": dashboard(DemoStationID, map[string]float64{"AbsolutePressure": last(DemoStationID)["Pressure"] - 5}),
+		"place": map[string]interface{}{
+			"city":     "Stockholm",
+			"country":  "SE",
+			"timezone": "Europe/Stockholm",
+			"altitude": 30,
+			"location": []float64{18.0686, 59.3293},
+		},
+		"modules": []map[string]interface{}{
+			{
+				"_id":            DemoOutdoorID,
+				"module_name":    "Outdoor",
+				"type":           "NAModule1",
+				"date_setup":     setup,
+				"data_type":      []string{"Temperature", "Humidity"},
+				"dashboard_data": dashboard(DemoOutdoorID, nil),
+			},
+			{
+				"_id":            DemoRainID,
+				"module_name":    "Rain",
+				"type":           "NAModule3",
+				"date_setup":     setup,
+				"data_type":      []string{"Rain"},
+				"dashboard_data": dashboard(DemoRainID, map[string]float64{"sum_rain_1": 0.2, "sum_rain_24": 1.4}),
+			},
+			{
+				"_id":            DemoWindID,
+				"module_name":    "Wind",
+				"type":           "NAModule2",
+				"date_setup":     setup,
+				"data_type":      []string{"Wind"},
+				"dashboard_data": dashboard(DemoWindID, nil),
+			},
+		},
+	}
+
+	b, err := json.Marshal(station)
+	if err != nil {
+		panic(err)
+	}
+
+	return &Fixtures{
+		Devices:  []json.RawMessage{b},
+		User:     json.RawMessage(`{"mail":"demo@example.com","administrative":{"unit":0,"windunit":0,"pressureunit":0}}`),
+		Measures: measures,
+		Public:   DemoPublic(now),
+	}
+}
+
+// DemoPublic returns eight public stations around the demo station, measured at now.
+// They all have temperature, humidity and pressure, every other one rain and every
+// fourth one wind.
+func DemoPublic(now int64) []json.RawMessage {
+	public := []json.RawMessage{}
+	for i := 0; i < 8; i++ {
+		id := fmt.Sprintf("70:ee:50:00:01:%02x", i)
+		measures := map[string]interface{}{
+			id: map[string]interface{}{
+				"res":  map[string][]float64{strconv.FormatInt(now, 10): {1010 + float64(i)}},
+				"type": []string{"pressure"},
+			},
+			fmt.Sprintf("02:00:00:00:01:%02x", i): map[string]interface{}{
+				"res":  map[string][]float64{strconv.FormatInt(now, 10): {7 + float64(i)/2, 70 + float64(i)}},
+				"type": []string{"temperature", "humidity"},
+			},
+		}
+		if i%2 == 0 {
+			measures[fmt.Sprintf("05:00:00:00:01:%02x", i)] = map[string]interface{}{
+				"rain_60min": 0.1 * float64(i), "rain_24h": 0.5 * float64(i), "rain_live": 0, "rain_timeutc": now,
+			}
+		}
+		if i%4 == 0 {
+			measures[fmt.Sprintf("06:00:00:00:01:%02x", i)] = map[string]interface{}{
+				"wind_strength": 10 + i, "wind_angle": 45 * i, "gust_strength": 18 + i, "gust_angle": 45*i + 10, "wind_timeutc": now,
+			}
+		}
+
+		b, err := json.Marshal(map[string]interface{}{
+			"_id": id,
+			"place": map[string]interface{}{
+				"city":     "Stockholm",
+				"country":  "SE",
+				"timezone": "Europe/Stockholm",
+				"altitude": 20 + 5*i,
+				// in a ring of about 2 km around the demo station
+				"location": []float64{18.0686 + 0.03*math.Cos(float64(i)*math.Pi/4), 59.3293 + 0.015*math.Sin(float64(i)*math.Pi/4)},
+			},
+			"mark":     10,
+			"measures": measures,
+			"modules":  []string{fmt.Sprintf("02:00:00:00:01:%02x", i)},
+		})
+		if err != nil {
+			panic(err)
+		}
+		public = append(public, b)
+	}
+	return public
+}
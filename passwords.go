@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+)
+
+// secretEnv are the environment variables holding secrets, they are not
+// passed on to helper commands
+var secretEnv = []string{"NETATMO_PASSWORD", "NETATMO_CLIENT_SECRET"}
+
+var (
+	// resolved holds every secret read so far, for redaction
+	resolved = []string{}
+
+	// stdinSecret is the secret read from stdin, stdin can only be read once
+	stdinSecret *string
+)
+
+// resolveSecret reads the secret called name from the source configured for it,
+// --name, --name-file, --name-stdin or --name-command. Sources given as flags
+// replace the ones from the profile, only one source may be used.
+func resolveSecret(ctx *cli.Context, name string, value, file, command *string) error {
+	flagValue := ctx.GlobalString(name)
+	flagFile := ctx.GlobalString(name + "-file")
+	flagCommand := ctx.GlobalString(name + "-command")
+	stdin := ctx.GlobalBool(name + "-stdin")
+
+	if flagValue != "" || flagFile != "" || flagCommand != "" || stdin {
+		*value, *file, *command = flagValue, flagFile, flagCommand
+	}
+
+	sources := 0
+	for _, source := range []string{*value, *file, *command} {
+		if source != "" {
+			sources++
+		}
+	}
+	if stdin {
+		sources++
+	}
+	if sources > 1 {
+		return fmt.Errorf("more than one source given for the %s", name)
+	}
+
+	var err error
+	switch {
+	case stdin:
+		*value, err = readSecretStdin()
+	case *file != "":
+		*value, err = readSecretFile(expandHome(*file))
+	case *command != "":
+		*value, err = runSecretCommand(*command)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to read the %s: %s", name, err.Error())
+	}
+
+	if *value != "" {
+		resolved = append(resolved, *value)
+	}
+	return nil
+}
+
+// readSecretStdin reads the first line from stdin
+func readSecretStdin() (string, error) {
+	if stdinSecret != nil {
+		return *stdinSecret, nil
+	}
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", errors.New("nothing on stdin")
+	}
+
+	secret := strings.TrimRight(line, "\r\n")
+	stdinSecret = &secret
+	return secret, nil
+}
+
+// readSecretFile reads the secret from the first line of the file
+func readSecretFile(path string) (string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	if info, err := os.Stat(path); err == nil && info.Mode().Perm()&0077 != 0 {
+		log.WithFields(log.Fields{
+			"path": path,
+		}).Warn("secret file is readable by other users")
+	}
+
+	return strings.TrimRight(strings.SplitN(string(b), "\n", 2)[0], "\r"), nil
+}
+
+// runSecretCommand runs a helper such as `pass show netatmo` through the shell
+// and reads the secret from the first line of its output
+func runSecretCommand(command string) (string, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("sh", "-c", command)
+	}
+
+	cmd.Env = []string{}
+	for _, env := range os.Environ() {
+		if !isSecretEnv(env) {
+			cmd.Env = append(cmd.Env, env)
+		}
+	}
+
+	stdout := &bytes.Buffer{}
+	cmd.Stdout = stdout
+	cmd.Stderr = os.Stderr
+	cmd.Stdin = os.Stdin
+
+	// the output is left out of the error, it might contain the secret
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("%q failed: %s", command, err.Error())
+	}
+
+	return strings.TrimRight(strings.SplitN(stdout.String(), "\n", 2)[0], "\r"), nil
+}
+
+func isSecretEnv(env string) bool {
+	for _, name := range secretEnv {
+		if strings.HasPrefix(env, name+"=") {
+			return true
+		}
+	}
+	return false
+}
+
+// redact replaces every resolved secret in s, use it on anything logged
+// that might contain one
+func redact(s string) string {
+	for _, secret := range resolved {
+		s = strings.Replace(s, secret, "[redacted]", -1)
+	}
+	return s
+}
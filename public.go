@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/codegangsta/cli"
+	"github.com/dhogborg/atnetgo/stations"
+	netatmo "github.com/dhogborg/netatmo-api-go"
+)
+
+// public prints the community stations in the box given with --ne-lat, --ne-lon,
+// --sw-lat and --sw-lon, with the statistics of their values, in the format given with --format
+func public(ctx context.Context, c *cli.Context) {
+	p := profile
+
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	printer, err := stations.NewPublicPrinter(outputFormat(c), p.Units)
+	if err != nil {
+		fatal(ctx, err, profileFields(p), "configuration error")
+	}
+	q, err := publicQuery(c)
+	if err != nil {
+		fatal(ctx, err, profileFields(p), "configuration error")
+	}
+
+	config, err := clientConfig(p)
+	if err != nil {
+		fatal(ctx, err, profileFields(p), "configuration error")
+	}
+	n, err := newClient(ctx, p, config)
+	if err != nil {
+		fatal(ctx, err, profileFields(p), "unable to read public data")
+	}
+	found, err := n.ReadPublic(ctx, q)
+	if err != nil {
+		fatal(ctx, err, profileFields(p), "unable to read public data")
+	}
+
+	if err := printer.PrintPublic(os.Stdout, stations.NewPublic(found)); err != nil {
+		fatal(ctx, err, profileFields(p), "unable to print")
+	}
+}
+
+// publicQuery reads the area and required data of the public command
+func publicQuery(c *cli.Context) (netatmo.PublicQuery, error) {
+	q := netatmo.PublicQuery{Filter: c.Bool("filter")}
+
+	for _, name := range []string{"ne-lat", "ne-lon", "sw-lat", "sw-lon"} {
+		if !c.IsSet(name) {
+			return q, fmt.Errorf("--%s is needed", name)
+		}
+	}
+	q.Area = netatmo.Area{
+		LatNE: c.Float64("ne-lat"),
+		LonNE: c.Float64("ne-lon"),
+		LatSW: c.Float64("sw-lat"),
+		LonSW: c.Float64("sw-lon"),
+	}
+	for _, lat := range []float64{q.LatNE, q.LatSW} {
+		if lat < -90 || lat > 90 {
+			return q, fmt.Errorf("latitude %g is not within -90 and 90", lat)
+		}
+	}
+	for _, lon := range []float64{q.LonNE, q.LonSW} {
+		if lon < -180 || lon > 180 {
+			return q, fmt.Errorf("longitude %g is not within -180 and 180", lon)
+		}
+	}
+	if q.LatNE <= q.LatSW {
+		return q, fmt.Errorf("--ne-lat %g is not north of --sw-lat %g", q.LatNE, q.LatSW)
+	}
+
+	for _, data := range splitList(c.String("required-data")) {
+		data = strings.ToLower(data)
+		if !contains(netatmo.PublicData, data) {
+			return q, fmt.Errorf("unknown required data %q, use %s", data, strings.Join(netatmo.PublicData, ", "))
+		}
+		q.RequiredData = append(q.RequiredData, data)
+	}
+	return q, nil
+}
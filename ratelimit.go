@@ -0,0 +1,29 @@
+package main
+
+import (
+	netatmo "github.com/dhogborg/netatmo-api-go"
+)
+
+// Rate limit modes
+const (
+	// rateLimitWait waits until the quotas allow a request
+	rateLimitWait = "wait"
+	// rateLimitFail fails with exitRateLimited instead of waiting
+	rateLimitFail = "fail"
+	// rateLimitOff sends requests without limiting
+	rateLimitOff = "off"
+)
+
+// newLimiter returns the rate limiter for the account of the profile. Its state is
+// kept next to the token file by default, so every process using the token shares it.
+func newLimiter(p *Profile) netatmo.Limiter {
+	if p.RateLimit == rateLimitOff {
+		return nil
+	}
+
+	path := p.RateLimitFile
+	if path == "" {
+		path = tokenPath(p) + ".ratelimit"
+	}
+	return netatmo.NewLimiter(netatmo.DefaultRateLimits, path, p.RateLimit == rateLimitFail)
+}
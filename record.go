@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	netatmo "github.com/dhogborg/netatmo-api-go"
+)
+
+// scrubbed replaces tokens and other values left out of recorded responses
+const scrubbed = "[scrubbed]"
+
+// recorded counts the responses saved by this process, to keep file names unique
+var recorded int64
+
+// recordingTransport saves the body of every response to dir before handing it on
+type recordingTransport struct {
+	dir           string
+	account       string
+	scrubLocation bool
+	next          http.RoundTripper
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	// the response is good regardless, a failed recording is not fatal
+	if path, err := t.save(req, resp.StatusCode, body); err != nil {
+		log.WithFields(errorFields(err, log.Fields{
+			"dir": t.dir,
+		})).Warn("unable to record response")
+	} else {
+		log.WithFields(log.Fields{
+			"path": path,
+		}).Debug("recorded response")
+	}
+
+	return resp, nil
+}
+
+// save writes body to a file named after the time, account and endpoint,
+// with the status appended for failed requests
+func (t *recordingTransport) save(req *http.Request, status int, body []byte) (string, error) {
+	if err := os.MkdirAll(t.dir, 0700); err != nil {
+		return "", err
+	}
+
+	endpoint := path.Base(req.URL.Path)
+	if endpoint == "token" {
+		endpoint = "oauth2-token"
+	}
+	if status != http.StatusOK {
+		endpoint = fmt.Sprintf("%s-%d", endpoint, status)
+	}
+
+	name := fmt.Sprintf("%s-%s-%03d-%s.json",
+		time.Now().Format("20060102T150405.000"), t.account, atomic.AddInt64(&recorded, 1), endpoint)
+	file := filepath.Join(t.dir, name)
+
+	// responses are kept as they came unless something has to be scrubbed
+	if endpoint == "oauth2-token" || t.scrubLocation {
+		body = scrubResponse(body, t.scrubLocation)
+	}
+	body = []byte(redact(string(body)))
+
+	return file, ioutil.WriteFile(file, body, 0600)
+}
+
+// scrubResponse replaces the tokens in a JSON response, and with location
+// the coordinates, altitude, city and street of the stations
+func scrubResponse(body []byte, location bool) []byte {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+
+	var v interface{}
+	if err := decoder.Decode(&v); err != nil {
+		// not JSON, nothing to scrub
+		return body
+	}
+
+	var scrub func(v interface{})
+	scrub = func(v interface{}) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			for key, value := range v {
+				switch {
+				case key == "access_token" || key == "refresh_token":
+					v[key] = scrubbed
+				case key == "place" && location:
+					if place, ok := value.(map[string]interface{}); ok {
+						place["location"] = []float64{0, 0}
+						place["altitude"] = 0
+						delete(place, "city")
+						delete(place, "street")
+					}
+				default:
+					scrub(value)
+				}
+			}
+		case []interface{}:
+			for _, value := range v {
+				scrub(value)
+			}
+		}
+	}
+	scrub(v)
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return body
+	}
+	return b
+}
+
+// replayDevices reads a getstationsdata response saved with --record,
+// or the same payload saved some other way
+func replayDevices(path string) (*netatmo.DeviceCollection, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	payload := struct {
+		Error *struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}{}
+	if err := json.Unmarshal(b, &payload); err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %s", path, err.Error())
+	}
+	if payload.Error != nil {
+		return nil, fmt.Errorf("%s holds an error response: %s (code %d)", path, payload.Error.Message, payload.Error.Code)
+	}
+
+	dc := &netatmo.DeviceCollection{}
+	if err := json.Unmarshal(b, dc); err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %s", path, err.Error())
+	}
+	if dc.Stations() == nil {
+		return nil, errors.New(path + " holds no getstationsdata response")
+	}
+	return dc, nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dhogborg/atnetgo/netatmotest"
+	netatmo "github.com/dhogborg/netatmo-api-go"
+)
+
+// credentialExchanges logs in to the emulator with the password grant and with an
+// authorization code, refreshes the token and reads the stations, all through client.
+// It returns every credential that was sent or received.
+func credentialExchanges(t *testing.T, client *http.Client) []string {
+	f := netatmotest.DemoFixtures()
+	f.ClientID, f.ClientSecret = "clientidlong123", "clientsecretlong123"
+	f.Username, f.Password = "me@example.com", "passwordlong123"
+	s := netatmotest.NewServer(f)
+	defer s.Close()
+
+	ctx := context.Background()
+	config := netatmo.Config{
+		ClientID:     f.ClientID,
+		ClientSecret: f.ClientSecret,
+		Username:     f.Username,
+		Password:     f.Password,
+		RedirectURL:  "http://127.0.0.1/callback",
+		BaseURL:      s.URL,
+		HTTPClient:   client,
+	}
+	oauth := netatmo.OAuthConfig(config)
+
+	token, err := netatmo.PasswordToken(ctx, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the browser part of the authorization, straight to the emulator
+	noRedirect := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := noRedirect.Get(oauth.AuthCodeURL("state"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	location, err := resp.Location()
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := location.Query().Get("code")
+	exchanged, err := oauth.Exchange(netatmo.OAuthContext(ctx, config), code)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// an expired token is refreshed before the stations are read
+	expired := *token
+	expired.Expiry = time.Now().Add(-time.Minute)
+	src := oauth.TokenSource(netatmo.OAuthContext(ctx, config), &expired)
+	if _, err := netatmo.NewClientWithTokenSource(ctx, config, src).Read(ctx); err != nil {
+		t.Fatal(err)
+	}
+	refreshed, err := src.Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refreshed.AccessToken == token.AccessToken {
+		t.Fatal("the token was not refreshed")
+	}
+
+	return []string{
+		token.AccessToken, token.RefreshToken,
+		exchanged.AccessToken, exchanged.RefreshToken,
+		refreshed.AccessToken, refreshed.RefreshToken,
+		code, f.Password, f.ClientSecret,
+	}
+}
+
+func TestRecordingTransportScrubsCredentials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "atnetgo-record")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	secrets := credentialExchanges(t, httpClient(&Profile{Name: "test", Record: dir}))
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	endpoints := map[string]int{}
+	for _, file := range files {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, secret := range secrets {
+			if strings.Contains(string(b), secret) {
+				t.Errorf("%s holds the credential %q", filepath.Base(file), secret)
+			}
+		}
+		endpoints[file[strings.LastIndex(file, "-")+1:]]++
+	}
+
+	// a password grant, a code exchange and a refresh, then the stations
+	if endpoints["token.json"] != 3 || endpoints["getstationsdata.json"] != 1 {
+		t.Errorf("got recordings %v, want 3 token responses and 1 getstationsdata", endpoints)
+	}
+}
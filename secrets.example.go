@@ -1,12 +1,14 @@
 package main
 
 // Create a new AppId at https://dev.netatmo.com
-// Make copy of this file and rename it to secrets.go (it will be ignored by source control)
+// The credentials can be given at runtime with --client-id/--client-secret or
+// NETATMO_CLIENT_ID/NETATMO_CLIENT_SECRET. To compile them into the binary instead,
+// make copy of this file and rename it to secrets.go (it will be ignored by source control)
 // In the new file, de-comment the code below and fill in your values.
 
 /*
-const (
+func init() {
     NetatmoAppID = ""
     NetatmoAppSecret = ""
-)
-*/
\ No newline at end of file
+}
+*/
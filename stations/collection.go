@@ -0,0 +1,121 @@
+// Package stations reads weather stations from the Netatmo API, filters them
+// and writes them in the output formats of atnetgo, for use in other programs.
+//
+//	client := netatmo.NewClientWithToken(ctx, config, token)
+//	devices, err := stations.Fetch(ctx, client, "Home")
+//	if err != nil {
+//		return err
+//	}
+//	printer, err := stations.NewPrinter(stations.FormatInflux, stations.UnitsMetric)
+//	if err != nil {
+//		return err
+//	}
+//	return printer.Print(os.Stdout, devices)
+package stations
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	netatmo "github.com/dhogborg/netatmo-api-go"
+)
+
+// DeviceCollection contains filterd device collection
+// Accounts : Names of the accounts read
+// Failed : Names of the accounts that could not be read
+// Stale : Names of the accounts that could not be read, served from a cache
+type DeviceCollection struct {
+	NetatmoStations []*netatmo.Device
+	Modules         []*netatmo.Device
+	Accounts        []string
+	Failed          []string
+	Stale           []string
+
+	// account each station was read from
+	stationAccounts map[*netatmo.Device]string
+	// when stations served from a cache were fetched
+	stationFetched map[*netatmo.Device]time.Time
+}
+
+func (d *DeviceCollection) Stations() []*netatmo.Device { return d.NetatmoStations }
+
+// Account returns the name of the account the station was read from
+func (d *DeviceCollection) Account(station *netatmo.Device) string {
+	return d.stationAccounts[station]
+}
+
+// MultiAccount is true when the collection was read from more than one account,
+// the output is then tagged with the account of each station
+func (d *DeviceCollection) MultiAccount() bool { return len(d.Accounts) > 1 }
+
+// Add appends the stations read from account. The account is listed in
+// Accounts by the caller, also when it could not be read.
+func (d *DeviceCollection) Add(account string, stations []*netatmo.Device) {
+	if d.stationAccounts == nil {
+		d.stationAccounts = map[*netatmo.Device]string{}
+	}
+	for _, station := range stations {
+		d.NetatmoStations = append(d.NetatmoStations, station)
+		d.stationAccounts[station] = account
+	}
+}
+
+// AddStale appends the stations of account served from a cache, fetched at the given time
+func (d *DeviceCollection) AddStale(account string, stations []*netatmo.Device, fetched time.Time) {
+	if d.stationFetched == nil {
+		d.stationFetched = map[*netatmo.Device]time.Time{}
+	}
+	d.Add(account, stations)
+	for _, station := range stations {
+		d.stationFetched[station] = fetched
+	}
+	d.Stale = append(d.Stale, account)
+}
+
+// Age returns how old the values of a station served from a cache are,
+// ok is false for stations read from the API
+func (d *DeviceCollection) Age(station *netatmo.Device) (age time.Duration, ok bool) {
+	fetched, ok := d.stationFetched[station]
+	if !ok {
+		return 0, false
+	}
+	return time.Since(fetched), true
+}
+
+// Reader reads the devices of an account, e.g. a *netatmo.Client
+type Reader interface {
+	Read(ctx context.Context) (*netatmo.DeviceCollection, error)
+}
+
+// Fetch reads the stations of an account from r and filters them by name, see Filter
+func Fetch(ctx context.Context, r Reader, filter string) (*DeviceCollection, error) {
+	dc, err := r.Read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return Filter(filter, dc), nil
+}
+
+// Filter returns the stations of dc whose name contains filter, all of them for an empty filter
+func Filter(filter string, dc *netatmo.DeviceCollection) *DeviceCollection {
+	collection := &DeviceCollection{
+		NetatmoStations: dc.Stations(),
+	}
+
+	if filter != "" {
+		stations := []*netatmo.Device{}
+		for _, station := range collection.Stations() {
+			if matchesFilter(station, filter) {
+				stations = append(stations, station)
+			}
+		}
+		collection.NetatmoStations = stations
+	}
+
+	return collection
+}
+
+func matchesFilter(device *netatmo.Device, filter string) bool {
+	return strings.Index(device.StationName, filter) > -1
+}
@@ -0,0 +1,255 @@
+package stations
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+
+	netatmo "github.com/dhogborg/netatmo-api-go"
+)
+
+// ComparisonPrinter writes comparisons with public stations to w in one of the
+// output formats, the printers of NewPrinter are all comparison printers as well
+type ComparisonPrinter interface {
+	PrintComparison(w io.Writer, deviations []Deviation) error
+}
+
+// NewComparisonPrinter returns the comparison printer for format, writing values in units
+func NewComparisonPrinter(format, units string) (ComparisonPrinter, error) {
+	printer, err := NewPrinter(format, units)
+	if err != nil {
+		return nil, err
+	}
+	return printer.(ComparisonPrinter), nil
+}
+
+// earthRadius is the mean radius of the earth in km
+const earthRadius = 6371.0
+
+// Distance returns the great circle distance in km between two points given in degrees
+func Distance(lat1, lon1, lat2, lon2 float64) float64 {
+	rad := func(deg float64) float64 { return deg * math.Pi / 180 }
+
+	dlat := rad(lat2 - lat1)
+	dlon := rad(lon2 - lon1)
+	a := math.Sin(dlat/2)*math.Sin(dlat/2) + math.Cos(rad(lat1))*math.Cos(rad(lat2))*math.Sin(dlon/2)*math.Sin(dlon/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(a))
+}
+
+// AreaAround returns the box around lat, lon holding the circle of radius km
+func AreaAround(lat, lon, radius float64) netatmo.Area {
+	dlat := radius / earthRadius * 180 / math.Pi
+	dlon := dlat / math.Max(math.Cos(lat*math.Pi/180), 0.01)
+	return netatmo.Area{
+		LatNE: math.Min(lat+dlat, 90),
+		LonNE: math.Min(lon+dlon, 180),
+		LatSW: math.Max(lat-dlat, -90),
+		LonSW: math.Max(lon-dlon, -180),
+	}
+}
+
+// Nearby returns the public stations within radius km of station, leaving out station itself
+func Nearby(station *netatmo.Device, public []*netatmo.PublicStation, radius float64) []*netatmo.PublicStation {
+	lat, lon, ok := station.Location()
+	if !ok {
+		return nil
+	}
+
+	nearby := []*netatmo.PublicStation{}
+	for _, p := range public {
+		if p.ID != station.ID && Distance(lat, lon, p.Latitude, p.Longitude) <= radius {
+			nearby = append(nearby, p)
+		}
+	}
+	return nearby
+}
+
+// compared are the data types compared with the neighbourhood by module type:
+// the outdoor values, and the pressure of the station
+var compared = map[string][]string{
+	"NAMain":    []string{netatmo.NAMainPressure},
+	"NAModule1": []string{netatmo.NAModule1Temperature, netatmo.NAModule1Humidity},
+}
+
+// Deviation is how far a value of a module is from the median of the neighbourhood, in metric units
+// Station, Module : Names of the station and the module
+// DataType : Temperature, Humidity or Pressure
+// Value : Latest value of the module
+// Median, Neighbours : Median of the public stations around and how many they are
+// Threshold : Largest deviation from the median that is not suspected drift
+// Drift : Whether the value deviates more than Threshold
+type Deviation struct {
+	Station    string
+	Module     string
+	DataType   string
+	Value      float64
+	Median     float64
+	Neighbours int
+	Threshold  float64
+	Drift      bool
+}
+
+// Difference returns the value minus the median
+func (d Deviation) Difference() float64 {
+	return d.Value - d.Median
+}
+
+// Compare returns the deviations of the outdoor temperature and humidity and the pressure
+// of station from the medians of public, by module. Data types without a threshold, or with
+// fewer than minNeighbours public stations having a value, are not compared.
+func Compare(station *netatmo.Device, public *Public, thresholds map[string]float64, minNeighbours int) []Deviation {
+	deviations := []Deviation{}
+	for _, module := range station.Modules() {
+		data := ModuleData(module, UnitsMetric)
+
+		for _, dataType := range compared[module.Type] {
+			threshold, ok := thresholds[dataType]
+			stats, known := public.Stats[dataType]
+			if !ok || !known || stats.Count < minNeighbours {
+				continue
+			}
+			value, ok := floatValue(data[dataType])
+			if !ok {
+				continue
+			}
+
+			d := Deviation{
+				Station:    station.StationName,
+				Module:     module.ModuleName,
+				DataType:   dataType,
+				Value:      value,
+				Median:     stats.Median,
+				Neighbours: stats.Count,
+				Threshold:  threshold,
+			}
+			d.Drift = math.Abs(d.Difference()) > threshold
+			deviations = append(deviations, d)
+		}
+	}
+	return deviations
+}
+
+// floatValue returns a sensor value of Device.Data as a float64
+func floatValue(value interface{}) (float64, bool) {
+	switch value := value.(type) {
+	case float32:
+		return float64(value), true
+	case int32:
+		return float64(value), true
+	}
+	return 0, false
+}
+
+// convertDifference returns a difference of dataType values in units,
+// unlike values it is not offset, e.g. 1 °C is 1.8 °F
+func convertDifference(dataType string, difference float64, units string) float32 {
+	return convert(dataType, difference, units) - convert(dataType, 0, units)
+}
+
+// deviationValues returns the value, median, difference and threshold of d in units, by name
+func deviationValues(d Deviation, units string) [][2]string {
+	return [][2]string{
+		{"value", ValueString(convert(d.DataType, d.Value, units))},
+		{"median", ValueString(convert(d.DataType, d.Median, units))},
+		{"deviation", ValueString(convertDifference(d.DataType, d.Difference(), units))},
+		{"threshold", ValueString(convertDifference(d.DataType, d.Threshold, units))},
+	}
+}
+
+// PrintComparison writes one line per figure: station: module: type: figure: value
+func (p ListPrinter) PrintComparison(w io.Writer, deviations []Deviation) error {
+	for _, d := range deviations {
+		prefix := d.Station + ": " + d.Module + ": " + d.DataType
+		for _, v := range deviationValues(d, p.Units) {
+			if _, err := fmt.Fprintf(w, "%s: %s: %s\n", prefix, v[0], v[1]); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintf(w, "%s: neighbours: %d\n%s: drift: %t\n", prefix, d.Neighbours, prefix, d.Drift); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PrintComparison writes a single json object with the deviations by station, module and type
+func (p JSONPrinter) PrintComparison(w io.Writer, deviations []Deviation) error {
+	block := map[string]map[string]map[string]interface{}{}
+	for _, d := range deviations {
+		if block[d.Station] == nil {
+			block[d.Station] = map[string]map[string]interface{}{}
+		}
+		if block[d.Station][d.Module] == nil {
+			block[d.Station][d.Module] = map[string]interface{}{}
+		}
+
+		dblock := map[string]interface{}{"neighbours": d.Neighbours, "drift": d.Drift}
+		for _, v := range deviationValues(d, p.Units) {
+			dblock[v[0]] = v[1]
+		}
+		block[d.Station][d.Module][d.DataType] = dblock
+	}
+
+	b, err := json.Marshal(block)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(b))
+	return err
+}
+
+// PrintComparison writes the deviations under their station and module, for people
+func (p PrettyPrinter) PrintComparison(w io.Writer, deviations []Deviation) error {
+	station, module := "", ""
+	for i, d := range deviations {
+		if i == 0 || d.Station != station {
+			station, module = d.Station, ""
+			if _, err := fmt.Fprintf(w, "Station: %s\n", d.Station); err != nil {
+				return err
+			}
+		}
+		if d.Module != module {
+			module = d.Module
+			if _, err := fmt.Fprintf(w, "\t%s:\n", d.Module); err != nil {
+				return err
+			}
+		}
+
+		v := deviationValues(d, p.Units)
+		verdict := "ok"
+		if d.Drift {
+			verdict = "suspected drift, over " + v[3][1]
+		}
+		deviation := v[2][1]
+		if !strings.HasPrefix(deviation, "-") {
+			deviation = "+" + deviation
+		}
+		if _, err := fmt.Fprintf(w, "\t\t%s: %s, median %s of %d stations, deviation %s (%s)\n",
+			d.DataType, v[0][1], v[1][1], d.Neighbours, deviation, verdict); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PrintComparison writes a <type>_deviation point per deviation in InfluxDB line format,
+// tagged with station and module, with the figures as fields
+func (p InfluxPrinter) PrintComparison(w io.Writer, deviations []Deviation) error {
+	for _, d := range deviations {
+		tagstr := "station=" + strings.ToLower(d.Station) + ",module=" + strings.ToLower(d.Module)
+		tagstr = strings.Replace(tagstr, " ", "_", -1)
+
+		fields := []string{}
+		for _, v := range deviationValues(d, p.Units) {
+			fields = append(fields, v[0]+"="+v[1])
+		}
+		fields = append(fields, fmt.Sprintf("neighbours=%di", d.Neighbours), fmt.Sprintf("drift=%t", d.Drift))
+
+		if _, err := fmt.Fprintf(w, "%s_deviation,%s %s\n", strings.ToLower(d.DataType), tagstr, strings.Join(fields, ",")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package stations
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	netatmo "github.com/dhogborg/netatmo-api-go"
+)
+
+// History holds the measures of one module over time
+// Station, Module : Names of the station and the module
+// Types : Measure types in the order they are printed
+// Measures : The measures, oldest first
+type History struct {
+	Station  string
+	Module   string
+	Types    []string
+	Measures []netatmo.Measure
+}
+
+// HistoryPrinter writes histories to w in one of the output formats,
+// the printers of NewPrinter are all history printers as well
+type HistoryPrinter interface {
+	PrintHistory(w io.Writer, h *History) error
+}
+
+// NewHistoryPrinter returns the history printer for format, writing values in units
+func NewHistoryPrinter(format, units string) (HistoryPrinter, error) {
+	printer, err := NewPrinter(format, units)
+	if err != nil {
+		return nil, err
+	}
+	return printer.(HistoryPrinter), nil
+}
+
+// measureValue returns a value of a measure for ValueString, converted to units
+func measureValue(dataType string, value float64, units string) interface{} {
+	if integerTypes[strings.ToLower(dataType)] {
+		return int64(value)
+	}
+
+	return convert(dataType, value, units)
+}
+
+// convert returns value of dataType in units
+func convert(dataType string, value float64, units string) float32 {
+	v := float32(value)
+	if units == UnitsImperial {
+		if toImperial, ok := imperial[dataType]; ok {
+			v = toImperial(v)
+		}
+	}
+	return v
+}
+
+// PrintHistory writes one line per value: station: module: time: type: value
+func (p ListPrinter) PrintHistory(w io.Writer, h *History) error {
+	for _, m := range h.Measures {
+		for _, dataType := range h.Types {
+			value, ok := m.Values[dataType]
+			if !ok {
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "%s: %s: %s: %s: %s\n", h.Station, h.Module, m.Time.Format(time.RFC3339),
+				dataType, ValueString(measureValue(dataType, value, p.Units))); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// PrintHistory writes a single json object with the values by station,
+// module and unix time of the measure
+func (p JSONPrinter) PrintHistory(w io.Writer, h *History) error {
+	mblock := map[string]map[string]string{}
+	for _, m := range h.Measures {
+		values := map[string]string{}
+		for dataType, value := range m.Values {
+			values[dataType] = ValueString(measureValue(dataType, value, p.Units))
+		}
+		mblock[strconv.FormatInt(m.Time.Unix(), 10)] = values
+	}
+
+	block := map[string]interface{}{
+		h.Station: map[string]interface{}{h.Module: mblock},
+	}
+
+	b, err := json.Marshal(block)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(b))
+	return err
+}
+
+// PrintHistory writes the station and module followed by a line per measure
+func (p PrettyPrinter) PrintHistory(w io.Writer, h *History) error {
+	if _, err := fmt.Fprintf(w, "Station: %s\n\t%s:\n", h.Station, h.Module); err != nil {
+		return err
+	}
+
+	for _, m := range h.Measures {
+		values := []string{}
+		for _, dataType := range h.Types {
+			if value, ok := m.Values[dataType]; ok {
+				values = append(values, dataType+": "+ValueString(measureValue(dataType, value, p.Units)))
+			}
+		}
+		if _, err := fmt.Fprintf(w, "\t\t%s: %s\n", m.Time.Format("2006-01-02 15:04"), strings.Join(values, ", ")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PrintHistory writes the values in InfluxDB line format with the time of the measure
+func (p InfluxPrinter) PrintHistory(w io.Writer, h *History) error {
+	tagstr := "station=" + strings.ToLower(h.Station) + ",module=" + strings.ToLower(h.Module)
+	tagstr = strings.Replace(tagstr, " ", "_", -1)
+
+	for _, m := range h.Measures {
+		for _, dataType := range h.Types {
+			value, ok := m.Values[dataType]
+			if !ok {
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "%s,%s value=%s%s %d\n", strings.ToLower(dataType), tagstr,
+				ValueString(measureValue(dataType, value, p.Units)), influxSuffix(dataType), m.Time.UnixNano()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,226 @@
+package stations
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Output formats, see NewPrinter
+const (
+	FormatList   = "list"
+	FormatJSON   = "json"
+	FormatPretty = "pretty"
+	FormatInflux = "influx"
+)
+
+// Printer writes device collections to w in one of the output formats.
+// Histories, public stations and comparisons with them are written by
+// HistoryPrinter, PublicPrinter and ComparisonPrinter.
+type Printer interface {
+	Print(w io.Writer, devices *DeviceCollection) error
+}
+
+// NewPrinter returns the printer for format, writing values in units
+func NewPrinter(format, units string) (Printer, error) {
+	switch units {
+	case UnitsMetric, UnitsImperial:
+	default:
+		return nil, fmt.Errorf("unknown units %q, use %s or %s", units, UnitsMetric, UnitsImperial)
+	}
+
+	switch format {
+	case FormatList:
+		return ListPrinter{Units: units}, nil
+	case FormatJSON:
+		return JSONPrinter{Units: units}, nil
+	case FormatPretty:
+		return PrettyPrinter{Units: units}, nil
+	case FormatInflux:
+		return InfluxPrinter{Units: units}, nil
+	}
+	return nil, fmt.Errorf("unknown format %q, use %s, %s, %s or %s", format, FormatList, FormatJSON, FormatPretty, FormatInflux)
+}
+
+// ListPrinter writes one greppable line per value: station: module: type: value
+// Units : UnitsMetric or UnitsImperial
+type ListPrinter struct {
+	Units string
+}
+
+func (p ListPrinter) Print(w io.Writer, devices *DeviceCollection) error {
+	for _, station := range devices.Stations() {
+		prefix := station.StationName
+		if devices.MultiAccount() {
+			prefix = devices.Account(station) + ": " + prefix
+		}
+
+		for _, module := range station.Modules() {
+			data := ModuleData(module, p.Units)
+			for dataType, value := range data {
+				if _, err := fmt.Fprintf(w, "%s: %s: %s: %s\n", prefix, module.ModuleName, dataType, ValueString(value)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// JSONPrinter writes a single json object with the values by station and module,
+// grouped by account when read from several. Next to its modules a station has a
+// "meta" object with the account it was read from, when several, and the age in
+// seconds of stale values.
+// Units : UnitsMetric or UnitsImperial
+type JSONPrinter struct {
+	Units string
+}
+
+func (p JSONPrinter) Print(w io.Writer, devices *DeviceCollection) error {
+
+	block := map[string]interface{}{}
+
+	for _, station := range devices.Stations() {
+		sblock := map[string]interface{}{}
+		for _, module := range station.Modules() {
+			mblock := map[string]string{}
+			data := ModuleData(module, p.Units)
+			for dataType, value := range data {
+				mblock[dataType] = ValueString(value)
+			}
+			sblock[module.ModuleName] = mblock
+		}
+		meta := map[string]interface{}{}
+		if age, ok := devices.Age(station); ok {
+			meta["age"] = int64(age / time.Second)
+		}
+
+		// with several accounts stations are grouped per account
+		if devices.MultiAccount() {
+			account := devices.Account(station)
+			meta["account"] = account
+			sblock["meta"] = meta
+			ablock, ok := block[account].(map[string]interface{})
+			if !ok {
+				ablock = map[string]interface{}{}
+				block[account] = ablock
+			}
+			ablock[station.StationName] = sblock
+			continue
+		}
+		if len(meta) > 0 {
+			sblock["meta"] = meta
+		}
+		block[station.StationName] = sblock
+	}
+
+	b, err := json.Marshal(block)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(b))
+	return err
+}
+
+// PrettyPrinter writes the stations and their modules indented, for people
+// Units : UnitsMetric or UnitsImperial
+type PrettyPrinter struct {
+	Units string
+}
+
+func (p PrettyPrinter) Print(w io.Writer, devices *DeviceCollection) error {
+	for _, station := range devices.Stations() {
+		notes := []string{}
+		if devices.MultiAccount() {
+			notes = append(notes, devices.Account(station))
+		}
+		if age, ok := devices.Age(station); ok {
+			notes = append(notes, "stale, "+(age-age%time.Second).String()+" old")
+		}
+
+		header := fmt.Sprintf("Station: %s\n", station.StationName)
+		if len(notes) > 0 {
+			header = fmt.Sprintf("Station: %s (%s)\n", station.StationName, strings.Join(notes, ", "))
+		}
+		if _, err := io.WriteString(w, header); err != nil {
+			return err
+		}
+
+		for _, module := range station.Modules() {
+			if _, err := fmt.Fprintf(w, "\t%s:\n", module.ModuleName); err != nil {
+				return err
+			}
+			data := ModuleData(module, p.Units)
+			for dataType, value := range data {
+				if _, err := fmt.Fprintf(w, "\t\t%s: %s\n", dataType, ValueString(value)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// InfluxPrinter writes the values in InfluxDB line format, tagged with station
+// and module, the account when read from several and stale=true for stale stations
+// Units : UnitsMetric or UnitsImperial
+type InfluxPrinter struct {
+	Units string
+}
+
+func (p InfluxPrinter) Print(w io.Writer, devices *DeviceCollection) error {
+	for _, station := range devices.Stations() {
+		tags := []string{"station=" + strings.ToLower(station.StationName), ""}
+		if devices.MultiAccount() {
+			tags = append(tags, "account="+strings.ToLower(devices.Account(station)))
+		}
+		if _, ok := devices.Age(station); ok {
+			tags = append(tags, "stale=true")
+		}
+
+		for _, module := range station.Modules() {
+			tags[1] = "module=" + strings.ToLower(module.ModuleName)
+
+			data := ModuleData(module, p.Units)
+			for dataType, value := range data {
+				tagstr := strings.Join(tags, ",")
+				tagstr = strings.Replace(tagstr, " ", "_", -1)
+				if _, err := fmt.Fprintf(w, "%s,%s value=%s%s\n", strings.ToLower(dataType), tagstr, ValueString(value), influxSuffix(dataType)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// integerTypes are measured in whole numbers
+var integerTypes = map[string]bool{
+	"co2":      true,
+	"humidity": true,
+	"noise":    true,
+}
+
+// influxSuffix marks the values that should be represented as integers
+func influxSuffix(dataType string) string {
+	if integerTypes[strings.ToLower(dataType)] {
+		return "i"
+	}
+	return ""
+}
+
+// ValueString formats a sensor value, floats with two decimals
+func ValueString(value interface{}) string {
+	switch value := value.(type) {
+	case float32, float64:
+		return fmt.Sprintf("%0.2f", value)
+	case string:
+		return value
+	case int, int32, int64:
+		return fmt.Sprintf("%d", value)
+	default:
+		return "-"
+	}
+}
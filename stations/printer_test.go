@@ -0,0 +1,356 @@
+package stations
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	netatmo "github.com/dhogborg/netatmo-api-go"
+)
+
+const testDevices = `{"body":{"devices":[
+	{"_id":"70:ee:50:00:00:01","station_name":"Home","module_name":"Indoor","type":"NAMain",
+	 "dashboard_data":{"Temperature":21.5,"Humidity":40,"CO2":600,"Noise":35,"Pressure":1013.2,"AbsolutePressure":1008.2},
+	 "modules":[{"_id":"02:00:00:00:00:01","module_name":"Outdoor","type":"NAModule1","dashboard_data":{"Temperature":10,"Humidity":80}}]},
+	{"_id":"70:ee:50:00:00:02","station_name":"Cabin","module_name":"Living room","type":"NAMain",
+	 "dashboard_data":{"Temperature":15,"Humidity":50,"CO2":400,"Noise":30,"Pressure":1000,"AbsolutePressure":990}}
+]}}`
+
+func testCollection(t *testing.T) *netatmo.DeviceCollection {
+	dc := &netatmo.DeviceCollection{}
+	if err := json.Unmarshal([]byte(testDevices), dc); err != nil {
+		t.Fatal(err)
+	}
+	return dc
+}
+
+// sortedLines returns the lines of s sorted, values within a module come in random order
+func sortedLines(s string) []string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	sort.Strings(lines)
+	return lines
+}
+
+func printString(t *testing.T, format, units string, devices *DeviceCollection) string {
+	printer, err := NewPrinter(format, units)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &bytes.Buffer{}
+	if err := printer.Print(b, devices); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+type reader struct {
+	dc  *netatmo.DeviceCollection
+	err error
+}
+
+func (r reader) Read(ctx context.Context) (*netatmo.DeviceCollection, error) { return r.dc, r.err }
+
+func TestFetch(t *testing.T) {
+	devices, err := Fetch(context.Background(), reader{dc: testCollection(t)}, "Cab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(devices.Stations()) != 1 || devices.Stations()[0].StationName != "Cabin" {
+		t.Errorf("got %v, want the Cabin station", devices.Stations())
+	}
+
+	devices, err = Fetch(context.Background(), reader{dc: testCollection(t)}, "")
+	if err != nil || len(devices.Stations()) != 2 {
+		t.Errorf("got %v, %v, want both stations", devices, err)
+	}
+
+	if _, err := Fetch(context.Background(), reader{err: errors.New("down")}, ""); err == nil {
+		t.Error("got no error from a failing reader")
+	}
+}
+
+func TestListPrinter(t *testing.T) {
+	got := sortedLines(printString(t, FormatList, UnitsMetric, Filter("Home", testCollection(t))))
+	want := []string{
+		"Home: Indoor: AbsolutePressure: 1008.20",
+		"Home: Indoor: CO2: 600",
+		"Home: Indoor: Humidity: 40",
+		"Home: Indoor: Noise: 35",
+		"Home: Indoor: Pressure: 1013.20",
+		"Home: Indoor: Temperature: 21.50",
+		"Home: Outdoor: Humidity: 80",
+		"Home: Outdoor: Temperature: 10.00",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestImperialUnits(t *testing.T) {
+	out := printString(t, FormatList, UnitsImperial, Filter("Home", testCollection(t)))
+	for _, want := range []string{"Home: Outdoor: Temperature: 50.00", "Home: Indoor: Pressure: 29.92"} {
+		if !strings.Contains(out, want+"\n") {
+			t.Errorf("missing %q in\n%s", want, out)
+		}
+	}
+}
+
+func TestJSONPrinter(t *testing.T) {
+	out := printString(t, FormatJSON, UnitsMetric, Filter("", testCollection(t)))
+
+	block := map[string]map[string]map[string]string{}
+	if err := json.Unmarshal([]byte(out), &block); err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+	if got := block["Home"]["Outdoor"]["Temperature"]; got != "10.00" {
+		t.Errorf("got Home Outdoor Temperature %q, want 10.00", got)
+	}
+	if got := block["Cabin"]["Living room"]["CO2"]; got != "400" {
+		t.Errorf("got Cabin Living room CO2 %q, want 400", got)
+	}
+}
+
+func TestPrettyPrinter(t *testing.T) {
+	out := printString(t, FormatPretty, UnitsMetric, Filter("Home", testCollection(t)))
+	if !strings.HasPrefix(out, "Station: Home\n\t") || !strings.Contains(out, "\tIndoor:\n\t\t") || !strings.Contains(out, "\tOutdoor:\n\t\t") {
+		t.Errorf("unexpected output\n%s", out)
+	}
+}
+
+func TestInfluxPrinter(t *testing.T) {
+	out := printString(t, FormatInflux, UnitsMetric, Filter("Cabin", testCollection(t)))
+	for _, want := range []string{
+		"co2,station=cabin,module=living_room value=400i",
+		"temperature,station=cabin,module=living_room value=15.00",
+	} {
+		if !strings.Contains(out, want+"\n") {
+			t.Errorf("missing %q in\n%s", want, out)
+		}
+	}
+}
+
+func TestMultiAccountAndStale(t *testing.T) {
+	devices := &DeviceCollection{Accounts: []string{"home", "cabin"}}
+	devices.Add("home", Filter("Home", testCollection(t)).Stations())
+	devices.AddStale("cabin", Filter("Cabin", testCollection(t)).Stations(), time.Now().Add(-90*time.Second))
+
+	if !devices.MultiAccount() || len(devices.Stale) != 1 || devices.Stale[0] != "cabin" {
+		t.Fatalf("got accounts %v and stale %v", devices.Accounts, devices.Stale)
+	}
+
+	influx := printString(t, FormatInflux, UnitsMetric, devices)
+	if !strings.Contains(influx, "co2,station=home,module=indoor,account=home value=600i\n") ||
+		!strings.Contains(influx, "co2,station=cabin,module=living_room,account=cabin,stale=true value=400i\n") {
+		t.Errorf("unexpected influx output\n%s", influx)
+	}
+
+	block := map[string]map[string]map[string]map[string]interface{}{}
+	if err := json.Unmarshal([]byte(printString(t, FormatJSON, UnitsMetric, devices)), &block); err != nil {
+		t.Fatal(err)
+	}
+	cabin, home := block["cabin"]["Cabin"], block["home"]["Home"]
+	if age, ok := cabin["meta"]["age"].(float64); !ok || age < 90 || age > 100 || cabin["meta"]["account"] != "cabin" {
+		t.Errorf("got cabin meta %v, want account cabin and age 90s", cabin["meta"])
+	}
+	if _, ok := home["meta"]["age"]; ok || home["meta"]["account"] != "home" {
+		t.Errorf("got home meta %v, want account home without age", home["meta"])
+	}
+	if cabin["Living room"]["CO2"] != "400" {
+		t.Errorf("got cabin modules %v", cabin)
+	}
+
+	pretty := printString(t, FormatPretty, UnitsMetric, devices)
+	if !strings.Contains(pretty, "Station: Home (home)\n") || !strings.Contains(pretty, "Station: Cabin (cabin, stale, 1m30s old)\n") {
+		t.Errorf("unexpected pretty output\n%s", pretty)
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) { return 0, errors.New("disk full") }
+
+func TestPrintWriteError(t *testing.T) {
+	for _, format := range []string{FormatList, FormatJSON, FormatPretty, FormatInflux} {
+		printer, err := NewPrinter(format, UnitsMetric)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := printer.Print(failingWriter{}, Filter("", testCollection(t))); err == nil {
+			t.Errorf("%s: got no error from a failing writer", format)
+		}
+	}
+}
+
+func TestNewPrinters(t *testing.T) {
+	for _, format := range []string{FormatList, FormatJSON, FormatPretty, FormatInflux} {
+		if _, err := NewHistoryPrinter(format, UnitsMetric); err != nil {
+			t.Errorf("%s: %s", format, err)
+		}
+		if _, err := NewPublicPrinter(format, UnitsMetric); err != nil {
+			t.Errorf("%s: %s", format, err)
+		}
+		if _, err := NewComparisonPrinter(format, UnitsMetric); err != nil {
+			t.Errorf("%s: %s", format, err)
+		}
+	}
+}
+
+func TestNewPrinterUnknown(t *testing.T) {
+	if _, err := NewPrinter("xml", UnitsMetric); err == nil {
+		t.Error("got a printer for an unknown format")
+	}
+	if _, err := NewPrinter(FormatList, "nautical"); err == nil {
+		t.Error("got a printer for unknown units")
+	}
+}
+
+func TestPrintHistory(t *testing.T) {
+	h := &History{
+		Station: "Home",
+		Module:  "Outdoor",
+		Types:   []string{"Temperature", "Humidity"},
+		Measures: []netatmo.Measure{
+			{Time: time.Unix(1700000000, 0), Values: map[string]float64{"Temperature": 10, "Humidity": 80}},
+			{Time: time.Unix(1700001800, 0), Values: map[string]float64{"Temperature": 9.5}},
+		},
+	}
+
+	want := map[string][]string{
+		FormatList: {
+			"Home: Outdoor: " + time.Unix(1700000000, 0).Format(time.RFC3339) + ": Temperature: 50.00",
+			"Home: Outdoor: " + time.Unix(1700000000, 0).Format(time.RFC3339) + ": Humidity: 80",
+			"Home: Outdoor: " + time.Unix(1700001800, 0).Format(time.RFC3339) + ": Temperature: 49.10",
+		},
+		FormatInflux: {
+			"temperature,station=home,module=outdoor value=50.00 1700000000000000000",
+			"humidity,station=home,module=outdoor value=80i 1700000000000000000",
+			"temperature,station=home,module=outdoor value=49.10 1700001800000000000",
+		},
+		FormatJSON: {`{"Home":{"Outdoor":{"1700000000":{"Humidity":"80","Temperature":"50.00"},"1700001800":{"Temperature":"49.10"}}}}`},
+	}
+	for format, lines := range want {
+		printer, err := NewHistoryPrinter(format, UnitsImperial)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := &bytes.Buffer{}
+		if err := printer.PrintHistory(b, h); err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.TrimSpace(b.String()); got != strings.Join(lines, "\n") {
+			t.Errorf("%s: got\n%s\nwant\n%s", format, got, strings.Join(lines, "\n"))
+		}
+	}
+}
+
+func TestPublic(t *testing.T) {
+	stations := []*netatmo.PublicStation{
+		{ID: "a", Values: map[string]float64{"Temperature": 10, "Humidity": 80}},
+		{ID: "b", Values: map[string]float64{"Temperature": 14}},
+		{ID: "c", Values: map[string]float64{"Temperature": 12, "Humidity": 60}},
+		{ID: "d", Values: map[string]float64{"Temperature": 20}},
+	}
+	public := NewPublic(stations)
+
+	if got, want := public.Stats["Temperature"], (Stats{Count: 4, Median: 13, Mean: 14, Min: 10, Max: 20}); got != want {
+		t.Errorf("got temperature stats %+v, want %+v", got, want)
+	}
+	if got, want := public.Stats["Humidity"], (Stats{Count: 2, Median: 70, Mean: 70, Min: 60, Max: 80}); got != want {
+		t.Errorf("got humidity stats %+v, want %+v", got, want)
+	}
+
+	printer, err := NewPublicPrinter(FormatList, UnitsImperial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &bytes.Buffer{}
+	if err := printer.PrintPublic(b, public); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"b: Temperature: 57.20", "stats: Temperature: count: 4", "stats: Temperature: median: 55.40", "stats: Humidity: max: 80.00"} {
+		if !strings.Contains(b.String(), want+"\n") {
+			t.Errorf("missing %q in\n%s", want, b.String())
+		}
+	}
+
+	stations[0].City, stations[0].Time = "Washington, D.C.", time.Unix(1500000000, 0)
+	stations[1].City = "New York"
+	printer, err = NewPublicPrinter(FormatInflux, UnitsMetric)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Reset()
+	if err := printer.PrintPublic(b, public); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`temperature,station=a,source=public,city=washington\,_d.c. value=10.00 1500000000000000000`,
+		`temperature,station=b,source=public,city=new_york value=14.00`,
+	} {
+		if !strings.Contains(b.String(), want+"\n") {
+			t.Errorf("missing %q in\n%s", want, b.String())
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	if d := Distance(59.3293, 18.0686, 57.7089, 11.9746); d < 395 || d > 400 {
+		t.Errorf("got %.1f km from Stockholm to Gothenburg, want about 397", d)
+	}
+
+	dc := &netatmo.DeviceCollection{}
+	err := json.Unmarshal([]byte(`{"body":{"devices":[
+		{"_id":"70:ee:50:00:00:01","station_name":"Home","module_name":"Indoor","type":"NAMain",
+		 "place":{"location":[18.0686,59.3293]},"dashboard_data":{"Temperature":21,"Pressure":1013},
+		 "modules":[{"_id":"02:00:00:00:00:01","module_name":"Outdoor","type":"NAModule1","dashboard_data":{"Temperature":14,"Humidity":60}}]}
+	]}}`), dc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	station := dc.Stations()[0]
+
+	public := []*netatmo.PublicStation{
+		{ID: "70:ee:50:00:00:01", Latitude: 59.3293, Longitude: 18.0686, Values: map[string]float64{"Temperature": 14}},
+		{ID: "a", Latitude: 59.33, Longitude: 18.07, Values: map[string]float64{"Temperature": 10, "Humidity": 62, "Pressure": 1012}},
+		{ID: "b", Latitude: 59.34, Longitude: 18.08, Values: map[string]float64{"Temperature": 11, "Humidity": 58, "Pressure": 1014}},
+		{ID: "c", Latitude: 59.32, Longitude: 18.05, Values: map[string]float64{"Temperature": 12, "Humidity": 60}},
+		{ID: "far", Latitude: 57.7089, Longitude: 11.9746, Values: map[string]float64{"Temperature": 30}},
+	}
+	nearby := Nearby(station, public, 5)
+	if len(nearby) != 3 {
+		t.Fatalf("got %d stations nearby, want a, b and c", len(nearby))
+	}
+
+	thresholds := map[string]float64{"Temperature": 2, "Humidity": 10, "Pressure": 3}
+	deviations := Compare(station, NewPublic(nearby), thresholds, 3)
+	if len(deviations) != 2 {
+		t.Fatalf("got %+v, want temperature and humidity only, pressure has two neighbours", deviations)
+	}
+	for _, d := range deviations {
+		switch d.DataType {
+		case "Temperature":
+			if d.Module != "Outdoor" || d.Median != 11 || d.Difference() != 3 || !d.Drift {
+				t.Errorf("unexpected temperature deviation %+v", d)
+			}
+		case "Humidity":
+			if d.Median != 60 || d.Drift {
+				t.Errorf("unexpected humidity deviation %+v", d)
+			}
+		}
+	}
+
+	influx := &bytes.Buffer{}
+	printer, _ := NewComparisonPrinter(FormatInflux, UnitsImperial)
+	if err := printer.PrintComparison(influx, deviations); err != nil {
+		t.Fatal(err)
+	}
+	want := "temperature_deviation,station=home,module=outdoor value=57.20,median=51.80,deviation=5.40,threshold=3.60,neighbours=3i,drift=true\n"
+	if !strings.HasPrefix(influx.String(), want) {
+		t.Errorf("got\n%swant\n%s", influx.String(), want)
+	}
+}
@@ -0,0 +1,246 @@
+package stations
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	netatmo "github.com/dhogborg/netatmo-api-go"
+)
+
+// Public holds community stations read with getpublicdata and statistics of their values
+// Stations : The stations in the order read
+// Stats : Statistics by data type, e.g. Temperature, over the stations with a value
+type Public struct {
+	Stations []*netatmo.PublicStation
+	Stats    map[string]Stats
+}
+
+// Stats summarizes the values of one data type, in metric units
+type Stats struct {
+	Count  int
+	Median float64
+	Mean   float64
+	Min    float64
+	Max    float64
+}
+
+// PublicPrinter writes public stations to w in one of the output formats,
+// the printers of NewPrinter are all public printers as well
+type PublicPrinter interface {
+	PrintPublic(w io.Writer, public *Public) error
+}
+
+// NewPublicPrinter returns the public printer for format, writing values in units
+func NewPublicPrinter(format, units string) (PublicPrinter, error) {
+	printer, err := NewPrinter(format, units)
+	if err != nil {
+		return nil, err
+	}
+	return printer.(PublicPrinter), nil
+}
+
+// NewPublic returns stations with the statistics of their values
+func NewPublic(stations []*netatmo.PublicStation) *Public {
+	values := map[string][]float64{}
+	for _, station := range stations {
+		for dataType, value := range station.Values {
+			values[dataType] = append(values[dataType], value)
+		}
+	}
+
+	stats := map[string]Stats{}
+	for dataType, v := range values {
+		sort.Float64s(v)
+
+		sum := 0.0
+		for _, value := range v {
+			sum += value
+		}
+		s := Stats{Count: len(v), Mean: sum / float64(len(v)), Min: v[0], Max: v[len(v)-1]}
+		if len(v)%2 == 1 {
+			s.Median = v[len(v)/2]
+		} else {
+			s.Median = (v[len(v)/2-1] + v[len(v)/2]) / 2
+		}
+		stats[dataType] = s
+	}
+
+	return &Public{Stations: stations, Stats: stats}
+}
+
+// DataTypes returns the data types with statistics, sorted
+func (p *Public) DataTypes() []string {
+	types := []string{}
+	for dataType := range p.Stats {
+		types = append(types, dataType)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// sortedValues returns the data types of values, sorted
+func sortedValues(values map[string]float64) []string {
+	types := []string{}
+	for dataType := range values {
+		types = append(types, dataType)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// statValues returns the statistics of s in units, by name
+func statValues(dataType string, s Stats, units string) [][2]string {
+	value := func(v float64) string { return ValueString(convert(dataType, v, units)) }
+	return [][2]string{
+		{"median", value(s.Median)},
+		{"mean", value(s.Mean)},
+		{"min", value(s.Min)},
+		{"max", value(s.Max)},
+	}
+}
+
+// PrintPublic writes one line per value: station id: type: value, followed by one
+// line per statistic: stats: type: statistic: value
+func (p ListPrinter) PrintPublic(w io.Writer, public *Public) error {
+	for _, station := range public.Stations {
+		if _, err := fmt.Fprintf(w, "%s: Location: %0.4f,%0.4f\n%s: Altitude: %0.0f\n", station.ID,
+			station.Latitude, station.Longitude, station.ID, station.Altitude); err != nil {
+			return err
+		}
+		for _, dataType := range sortedValues(station.Values) {
+			value := measureValue(dataType, station.Values[dataType], p.Units)
+			if _, err := fmt.Fprintf(w, "%s: %s: %s\n", station.ID, dataType, ValueString(value)); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, dataType := range public.DataTypes() {
+		s := public.Stats[dataType]
+		if _, err := fmt.Fprintf(w, "stats: %s: count: %d\n", dataType, s.Count); err != nil {
+			return err
+		}
+		for _, stat := range statValues(dataType, s, p.Units) {
+			if _, err := fmt.Fprintf(w, "stats: %s: %s: %s\n", dataType, stat[0], stat[1]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// PrintPublic writes a single json object with the stations by id and the statistics by type
+func (p JSONPrinter) PrintPublic(w io.Writer, public *Public) error {
+	stations := map[string]interface{}{}
+	for _, station := range public.Stations {
+		values := map[string]string{}
+		for dataType, value := range station.Values {
+			values[dataType] = ValueString(measureValue(dataType, value, p.Units))
+		}
+		stations[station.ID] = map[string]interface{}{
+			"latitude":  station.Latitude,
+			"longitude": station.Longitude,
+			"altitude":  station.Altitude,
+			"city":      station.City,
+			"country":   station.Country,
+			"time":      station.Time.Unix(),
+			"values":    values,
+		}
+	}
+
+	stats := map[string]interface{}{}
+	for dataType, s := range public.Stats {
+		sblock := map[string]interface{}{"count": s.Count}
+		for _, stat := range statValues(dataType, s, p.Units) {
+			sblock[stat[0]] = stat[1]
+		}
+		stats[dataType] = sblock
+	}
+
+	b, err := json.Marshal(map[string]interface{}{"stations": stations, "stats": stats})
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(b))
+	return err
+}
+
+// PrintPublic writes the stations with their place and values indented, then the statistics
+func (p PrettyPrinter) PrintPublic(w io.Writer, public *Public) error {
+	for _, station := range public.Stations {
+		place := []string{}
+		if station.City != "" {
+			place = append(place, station.City)
+		}
+		place = append(place, fmt.Sprintf("%0.4f, %0.4f, %0.0f m", station.Latitude, station.Longitude, station.Altitude))
+		if _, err := fmt.Fprintf(w, "Station: %s (%s)\n", station.ID, strings.Join(place, ", ")); err != nil {
+			return err
+		}
+
+		for _, dataType := range sortedValues(station.Values) {
+			value := measureValue(dataType, station.Values[dataType], p.Units)
+			if _, err := fmt.Fprintf(w, "\t%s: %s\n", dataType, ValueString(value)); err != nil {
+				return err
+			}
+		}
+	}
+
+	if _, err := fmt.Fprintf(w, "Statistics: %d stations\n", len(public.Stations)); err != nil {
+		return err
+	}
+	for _, dataType := range public.DataTypes() {
+		s := public.Stats[dataType]
+		stats := []string{fmt.Sprintf("count %d", s.Count)}
+		for _, stat := range statValues(dataType, s, p.Units) {
+			stats = append(stats, stat[0]+" "+stat[1])
+		}
+		if _, err := fmt.Fprintf(w, "\t%s: %s\n", dataType, strings.Join(stats, ", ")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cityTag makes a city name usable as tag value, spaces become underscores and the
+// commas and equal signs of e.g. "Washington, D.C." are escaped
+var cityTag = strings.NewReplacer(" ", "_", ",", "\\,", "=", "\\=")
+
+// PrintPublic writes the values in InfluxDB line format tagged with the station id and
+// source=public, at the time of the station's newest value, then a <type>_stats point
+// per data type with the statistics as fields. A station without a time leaves it to
+// InfluxDB.
+func (p InfluxPrinter) PrintPublic(w io.Writer, public *Public) error {
+	for _, station := range public.Stations {
+		tagstr := "station=" + strings.ToLower(station.ID) + ",source=public"
+		if station.City != "" {
+			tagstr += ",city=" + cityTag.Replace(strings.ToLower(station.City))
+		}
+		timestamp := ""
+		if !station.Time.IsZero() {
+			timestamp = fmt.Sprintf(" %d", station.Time.UnixNano())
+		}
+
+		for _, dataType := range sortedValues(station.Values) {
+			value := measureValue(dataType, station.Values[dataType], p.Units)
+			if _, err := fmt.Fprintf(w, "%s,%s value=%s%s%s\n", strings.ToLower(dataType), tagstr,
+				ValueString(value), influxSuffix(dataType), timestamp); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, dataType := range public.DataTypes() {
+		s := public.Stats[dataType]
+		fields := []string{fmt.Sprintf("count=%di", s.Count)}
+		for _, stat := range statValues(dataType, s, p.Units) {
+			fields = append(fields, stat[0]+"="+stat[1])
+		}
+		if _, err := fmt.Fprintf(w, "%s_stats,source=public %s\n", strings.ToLower(dataType), strings.Join(fields, ",")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package stations
+
+import (
+	netatmo "github.com/dhogborg/netatmo-api-go"
+)
+
+// Units of the printed values
+const (
+	UnitsMetric   = "metric"
+	UnitsImperial = "imperial"
+)
+
+// imperial converts the metric values delivered by Netatmo, by data type
+var imperial = map[string]func(float32) float32{
+	// °C to °F
+	netatmo.NAMainTemperature: func(v float32) float32 { return v*9/5 + 32 },
+	// mbar to inHg
+	netatmo.NAMainPressure:         func(v float32) float32 { return v * 0.02953 },
+	netatmo.NAMainAbsolutePressure: func(v float32) float32 { return v * 0.02953 },
+	// mm to in
+	netatmo.NAModule3Rain:      func(v float32) float32 { return v / 25.4 },
+	netatmo.NAModule3Rain1Hour: func(v float32) float32 { return v / 25.4 },
+	netatmo.NAModule3Rain1Day:  func(v float32) float32 { return v / 25.4 },
+	// km/h to mph
+	netatmo.NAModule2WindStrength: func(v float32) float32 { return v * 0.621371 },
+	netatmo.NAModule2GustStrength: func(v float32) float32 { return v * 0.621371 },
+}
+
+// ModuleData returns the sensor values of module in units, UnitsMetric or UnitsImperial
+func ModuleData(module *netatmo.Device, units string) map[string]interface{} {
+	_, data := module.Data()
+	if units != UnitsImperial {
+		return data
+	}
+
+	for dataType, value := range data {
+		if convert, ok := imperial[dataType]; ok {
+			if v, ok := value.(float32); ok {
+				data[dataType] = convert(v)
+			}
+		}
+	}
+	return data
+}
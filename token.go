@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	netatmo "github.com/dhogborg/netatmo-api-go"
+	"golang.org/x/oauth2"
+)
+
+// cachedTokenSource hands out tokens from src and writes every
+// new token back to the cache file, so a rotated refresh token survives
+// until the next invocation
+type cachedTokenSource struct {
+	path string
+	src  oauth2.TokenSource
+
+	mu   sync.Mutex // guards last
+	last *oauth2.Token
+}
+
+// newCachedTokenSource wraps src, token is the token currently in the cache file
+func newCachedTokenSource(path string, token *oauth2.Token, src oauth2.TokenSource) oauth2.TokenSource {
+	return &cachedTokenSource{
+		path: path,
+		src:  src,
+		last: token,
+	}
+}
+
+// Token returns a valid token, refreshing and storing it if needed
+func (s *cachedTokenSource) Token() (*oauth2.Token, error) {
+	token, err := s.src.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.last == nil || s.last.AccessToken != token.AccessToken || s.last.RefreshToken != token.RefreshToken {
+		// a refresh response without scopes keeps the ones granted at login
+		if netatmo.TokenScopes(token) == nil && s.last != nil && netatmo.TokenScopes(s.last) != nil {
+			token = withScopes(token, netatmo.TokenScopes(s.last))
+		}
+
+		// the token is still good for this run, so a failed write is not fatal
+		if err := saveToken(s.path, token); err != nil {
+			log.WithFields(errorFields(err, log.Fields{
+				"path": s.path,
+			})).Warn("unable to store refreshed token")
+		}
+		s.last = token
+	}
+
+	return token, nil
+}
+
+// configDir returns the directory where atnetgo keeps its files,
+// $XDG_CONFIG_HOME/atnetgo or ~/.config/atnetgo
+func configDir() string {
+	base := os.Getenv("XDG_CONFIG_HOME")
+	if base == "" {
+		base = filepath.Join(os.Getenv("HOME"), ".config")
+	}
+	return filepath.Join(base, "atnetgo")
+}
+
+// tokenPath returns the token file location, from the profile or the default
+// location. Profiles other than default get a file of their own by default,
+// the cache, rate limit and backfill files are named after it.
+func tokenPath(p *Profile) string {
+	if p.TokenFile != "" {
+		return p.TokenFile
+	}
+	if p.Name != "" && p.Name != "default" {
+		return filepath.Join(configDir(), "token-"+p.Name+".json")
+	}
+	return filepath.Join(configDir(), "token.json")
+}
+
+// errNotLoggedIn is the error for an account without a cached token, an AuthError
+// so that every command exits with exitAuth
+func errNotLoggedIn() error {
+	return &netatmo.AuthError{APIError: &netatmo.APIError{Message: "not logged in, run `atnetgo login` first"}}
+}
+
+// storedToken is the layout of the token file, the token and the scopes granted with it
+type storedToken struct {
+	*oauth2.Token
+	Scope []string `json:"scope,omitempty"`
+}
+
+// loadToken reads a token stored by saveToken
+func loadToken(path string) (*oauth2.Token, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	stored := storedToken{}
+	if err := json.Unmarshal(b, &stored); err != nil {
+		return nil, err
+	}
+	if stored.Token == nil || stored.AccessToken == "" {
+		return nil, errors.New("no token in " + path)
+	}
+	if len(stored.Scope) > 0 {
+		return withScopes(stored.Token, stored.Scope), nil
+	}
+	return stored.Token, nil
+}
+
+// withScopes returns a copy of token with scopes as its granted scopes
+func withScopes(token *oauth2.Token, scopes []string) *oauth2.Token {
+	return token.WithExtra(map[string]interface{}{"scope": scopes})
+}
+
+// saveToken writes the token to path, readable by the current user only.
+// The file is replaced atomically so concurrent runs never read a partial token.
+func saveToken(path string, token *oauth2.Token) error {
+	b, err := json.Marshal(storedToken{Token: token, Scope: netatmo.TokenScopes(token)})
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, b)
+}
+
+// writeFileAtomic replaces the file at path with b, readable by the current user only.
+// Readers see either the old or the new content, never a partial write.
+func writeFileAtomic(path string, b []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(dir, "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// credentialParams matches query and form parameters holding tokens, passwords and secrets
+var credentialParams = regexp.MustCompile(`(^|[?&])(access_token|refresh_token|password|client_secret|token|code)=[^&]*`)
+
+// credentialHeaders are left out of traced headers
+var credentialHeaders = []string{"Authorization", "Cookie", "Set-Cookie"}
+
+// tracingTransport logs every request and response in full, with credentials redacted
+type tracingTransport struct {
+	account string
+	next    http.RoundTripper
+}
+
+func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	fields := log.Fields{
+		"account":        t.account,
+		"method":         req.Method,
+		"url":            traceRedact(req.URL.String()),
+		"request_header": traceHeader(req.Header),
+	}
+
+	if req.Body != nil {
+		body, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		// the body is read, the request is passed on with a copy of it
+		clone := *req
+		clone.Body = ioutil.NopCloser(bytes.NewReader(body))
+		req = &clone
+
+		fields["request_body"] = traceRedact(string(body))
+	}
+
+	started := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	fields["duration"] = time.Since(started).String()
+	if err != nil {
+		log.WithFields(errorFields(err, fields)).Info("http request failed")
+		return nil, err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	fields["status"] = resp.StatusCode
+	fields["response_header"] = traceHeader(resp.Header)
+	fields["response_body"] = traceRedact(string(scrubResponse(body, false)))
+	log.WithFields(fields).Info("http exchange")
+
+	return resp, nil
+}
+
+// traceRedact replaces credentials in query strings and form bodies, and
+// the password and client secret wherever they appear
+func traceRedact(s string) string {
+	return redact(credentialParams.ReplaceAllString(s, "$1$2=[redacted]"))
+}
+
+// traceHeader formats header on one line, sorted, with credentials redacted
+func traceHeader(header http.Header) string {
+	lines := []string{}
+	for name, values := range header {
+		value := strings.Join(values, ", ")
+		for _, credential := range credentialHeaders {
+			if http.CanonicalHeaderKey(name) == credential {
+				value = "[redacted]"
+			}
+		}
+		lines = append(lines, name+": "+value)
+	}
+	sort.Strings(lines)
+	return redact(strings.Join(lines, "; "))
+}
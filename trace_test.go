@@ -0,0 +1,33 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+func TestTracingTransportRedactsCredentials(t *testing.T) {
+	b := &bytes.Buffer{}
+	log.SetOutput(b)
+	defer log.SetOutput(os.Stderr)
+
+	secrets := credentialExchanges(t, httpClient(&Profile{Name: "test", Trace: true}))
+
+	trace := b.String()
+	for _, secret := range secrets {
+		if strings.Contains(trace, secret) {
+			t.Errorf("the trace holds the credential %q", secret)
+		}
+	}
+
+	// a password grant, a code exchange and a refresh, then the stations
+	if n := strings.Count(trace, "oauth2/token"); n != 3 {
+		t.Errorf("%d token requests traced, want 3", n)
+	}
+	if !strings.Contains(trace, "api/getstationsdata") || !strings.Contains(trace, "grant_type=refresh_token") {
+		t.Errorf("incomplete trace\n%s", trace)
+	}
+}